@@ -50,7 +50,7 @@ let StreamManager = function () {
 
     this.start = function(id) {
         let c = this;
-        $.get("/streams/" + id + "/start", function() {
+        $.post("/streams/" + id + "/start", function() {
             c.refreshTable();
         }).fail(function(xhr) {
             console.error(xhr);
@@ -59,7 +59,7 @@ let StreamManager = function () {
 
     this.stop = function(id) {
         let c = this;
-        $.get("/streams/" + id + "/stop", function() {
+        $.post("/streams/" + id + "/stop", function() {
             c.refreshTable();
         }).fail(function(xhr) {
             console.error(xhr);
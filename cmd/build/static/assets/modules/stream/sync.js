@@ -0,0 +1,149 @@
+// Synchronized playback: players of cameras are kept at the same wall-clock time.
+// Playlists are padded from "from", so the position of a player is its offset plus the shared position.
+$(function() {
+    let $form = $("#form-sync"),
+        $sync = $("#sync"),
+        $position = $sync.find(".sync-position"),
+        $time = $sync.find(".sync-time"),
+        playback = null,
+        players = [],
+        playing = false,
+        position = 0, // seconds from "from"
+        timer = null;
+
+    $.ajax({
+        url: "/streams",
+    }).done(function(streams) {
+        let $select = $form.find("select[name=ids]");
+        $.each(streams, function(i, s) {
+            $select.append($("<option>", {value: s.id, text: "Camera-" + s.id + (s.name ? " " + s.name : "")}));
+        });
+    });
+
+    $form.submit(function(e) {
+        e.preventDefault();
+        let ids = $form.find("select[name=ids]").val() || [];
+        if (ids.length < 1) {
+            return;
+        }
+        $.ajax({
+            url: "/videos/sync",
+            data: {
+                ids: ids.join(","),
+                from: moment($form.find("input[name=from]").val()).format(),
+                to: moment($form.find("input[name=to]").val()).format(),
+            },
+        }).done(function(result) {
+            load(result);
+        });
+    });
+
+    function load(result) {
+        unload();
+        playback = result;
+        let $players = $sync.find(".sync-players");
+        $.each(playback.streams, function(i, s) {
+            let $col = $("<div>", {"class": "col"});
+            $col.append($("<div>", {"class": "small text-muted", text: "Camera-" + s.id + (s.name ? " " + s.name : "")}));
+            $col.append($("<video-js>", {id: "sync" + s.id, "class": "vjs-default-skin vjs-fluid"}));
+            $players.append($col);
+
+            let player = videojs("sync" + s.id, {
+                controls: false,
+                autoplay: false,
+                preload: "auto",
+            });
+            player.src({
+                "type": "application/x-mpegURL",
+                "src": s.playlist,
+            });
+            player.muted(i > 0);
+            players.push({player: player, offset: s.offset});
+        });
+        $position.attr("max", playback.to - playback.from).val(0);
+        position = 0;
+        seek(0);
+        $sync.removeClass("d-none");
+        timer = setInterval(tick, 500);
+    }
+
+    function unload() {
+        clearInterval(timer);
+        $.each(players, function(i, p) {
+            p.player.dispose();
+        });
+        players = [];
+        playing = false;
+        $sync.find(".sync-players").empty();
+        $sync.find(".sync-play i").attr("class", "fas fa-play");
+    }
+
+    function seek(pos) {
+        position = pos;
+        $.each(players, function(i, p) {
+            p.player.currentTime(p.offset + pos);
+        });
+        updateTime();
+    }
+
+    // The first player is the clock; the others are corrected when they drift
+    function tick() {
+        if (!playing || players.length < 1) {
+            return;
+        }
+        let master = players[0];
+        position = master.player.currentTime() - master.offset;
+        $.each(players.slice(1), function(i, p) {
+            if (Math.abs(p.player.currentTime() - (p.offset + position)) > 0.5) {
+                p.player.currentTime(p.offset + position);
+            }
+        });
+        if (position >= playback.to - playback.from) {
+            pause();
+        }
+        $position.val(Math.floor(position));
+        updateTime();
+    }
+
+    function updateTime() {
+        if (playback) {
+            $time.text(moment.unix(playback.from + position).format("YYYY-MM-DD HH:mm:ss"));
+        }
+    }
+
+    function play() {
+        playing = true;
+        seek(position);
+        $.each(players, function(i, p) {
+            p.player.play();
+        });
+        $sync.find(".sync-play i").attr("class", "fas fa-pause");
+    }
+
+    function pause() {
+        playing = false;
+        $.each(players, function(i, p) {
+            p.player.pause();
+        });
+        $sync.find(".sync-play i").attr("class", "fas fa-play");
+    }
+
+    $sync.find(".sync-play").click(function() {
+        if (playing) {
+            pause();
+            return;
+        }
+        play();
+    });
+
+    $sync.find(".sync-rate").change(function() {
+        let rate = parseFloat($(this).val());
+        $.each(players, function(i, p) {
+            p.player.playbackRate(rate);
+        });
+    });
+
+    $position.on("input", function() {
+        seek(parseInt($(this).val(), 10));
+    });
+});
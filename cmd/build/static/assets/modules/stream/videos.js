@@ -4,7 +4,7 @@ $(function() {
             controls: true,
             autoplay: false,
             preload: 'auto',
-            playbackRates: [0.5, 1, 1.5, 2, 4, 8],
+            playbackRates: [0.5, 1, 1.5, 2, 4, 8, 16],
         });
 
     updateVideos();
@@ -49,6 +49,7 @@ $(function() {
             let id = $(e.currentTarget).data("id"),
                 url = "/videos/" + id + "/date/" + row.date + "/m3u8";
             playVideo(url);
+            updateTimeline(id, row.date);
         },
         'click .live': function (e, val, row, idx) {
             let id = $(e.currentTarget).data("id"),
@@ -59,9 +60,51 @@ $(function() {
             let id = $(e.currentTarget).data("id"),
                 url = "/videos/" + id + "/today/m3u8";
             playVideo(url);
+            updateTimeline(id, moment().format("YYYYMMDD"));
         },
     };
 
+    function updateTimeline(id, date) {
+        $.ajax({
+            url: "/videos/" + id + "/timeline",
+            data: {from: date},
+        }).done(function(timeline) {
+            drawTimeline(timeline, date);
+        });
+    }
+
+    function drawTimeline(timeline, date) {
+        let $timeline = $("#timeline"),
+            $bar = $timeline.find(".timeline-bar"),
+            total = timeline.to - timeline.from;
+
+        $timeline.find(".timeline-title").text("Camera-" + timeline.streamId + " " + date);
+        $bar.empty();
+        $.each(timeline.ranges, function(i, r) {
+            $bar.append(timelineBlock(timeline.from, total, r, "bg-success", "Recorded"));
+        });
+        $.each(timeline.gaps, function(i, g) {
+            $bar.append(timelineBlock(timeline.from, total, g, "bg-danger", "Gap: " + g.reason));
+        });
+        $.each(timeline.annotations, function(i, a) {
+            let $block = timelineBlock(timeline.from, total, a, "bg-warning", a.label);
+            $block.css("min-width", "3px");
+            $bar.append($block);
+        });
+        $timeline.removeClass("d-none");
+    }
+
+    function timelineBlock(from, total, r, css, text) {
+        let left = (r.from - from) * 100 / total,
+            width = (r.to - r.from) * 100 / total,
+            title = text + " (" + moment.unix(r.from).format("HH:mm:ss") + " ~ " + moment.unix(r.to).format("HH:mm:ss") + ")";
+        return $("<div>", {
+            "class": "position-absolute h-100 " + css,
+            "title": title,
+            "style": "left: " + left + "%; width: " + width + "%; opacity: 0.7;",
+        });
+    }
+
     function playVideo(uri, live) {
         player.src({
             "type": "application/x-mpegURL",
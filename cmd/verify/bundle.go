@@ -0,0 +1,199 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/devplayg/rtsp-stream/common"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+type bundleReport struct {
+	Manifest       *common.BundleManifest `json:"manifest"`
+	SignatureValid bool                   `json:"signatureValid"`
+	KeySource      string                 `json:"keySource"` // pinned or embedded
+	Verified       int                    `json:"verified"`
+	Missing        []string               `json:"missing"`
+	Altered        []string               `json:"altered"`
+	Extra          []string               `json:"extra"`
+	Ok             bool                   `json:"ok"`
+}
+
+// verifyBundle validates the signature of the manifest and hashes of files in the bundle offline
+func verifyBundle(path, key string) (*bundleReport, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files[f.Name] = f
+	}
+
+	manifestData, err := readZipFile(files, common.BundleManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	signatureData, err := readZipFile(files, common.BundleSignatureFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &bundleReport{KeySource: "pinned"}
+	publicKey, err := readPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == nil {
+		data, err := readZipFile(files, common.BundlePublicKeyFileName)
+		if err != nil {
+			return nil, err
+		}
+		if publicKey, err = decodePublicKey(string(data)); err != nil {
+			return nil, err
+		}
+		report.KeySource = "embedded"
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signatureData)))
+	if err != nil {
+		return nil, err
+	}
+	report.SignatureValid = ed25519.Verify(publicKey, manifestData, signature)
+
+	var manifest common.BundleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, err
+	}
+	report.Manifest = &manifest
+
+	expected := make(map[string]bool)
+	list := append([]*common.BundleFile{}, manifest.Segments...)
+	if manifest.Clip != nil {
+		list = append(list, manifest.Clip)
+	}
+	for _, bf := range list {
+		expected[bf.Name] = true
+		f, ok := files[bf.Name]
+		if !ok {
+			report.Missing = append(report.Missing, bf.Name)
+			continue
+		}
+		size, hash, err := hashZipFile(f)
+		if err != nil || size != bf.Size || hash != bf.Sha256 {
+			report.Altered = append(report.Altered, bf.Name)
+			continue
+		}
+		report.Verified++
+	}
+	for name := range files {
+		switch name {
+		case common.BundleManifestFileName, common.BundleSignatureFileName, common.BundlePublicKeyFileName:
+			continue
+		}
+		if !expected[name] {
+			report.Extra = append(report.Extra, name)
+		}
+	}
+	sort.Strings(report.Extra)
+
+	// The key in the bundle proves nothing because whoever altered the bundle can replace it
+	report.Ok = report.KeySource == "pinned" && report.SignatureValid && len(report.Missing) == 0 && len(report.Altered) == 0 && len(report.Extra) == 0
+	return report, nil
+}
+
+// readPublicKey reads the base64 encoded public key from the string or file
+func readPublicKey(key string) (ed25519.PublicKey, error) {
+	if len(key) < 1 {
+		return nil, nil
+	}
+	if data, err := ioutil.ReadFile(key); err == nil {
+		key = string(data)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return decodePublicKey(key)
+}
+
+func decodePublicKey(str string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(str))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in bundle", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func hashZipFile(f *zip.File) (int64, string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func printBundleReport(path string, report *bundleReport) {
+	if *asJson {
+		b, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+
+	result := "OK"
+	if !report.Ok {
+		result = "FAILED"
+	}
+	m := report.Manifest
+	fmt.Printf("%s: %s\n", path, result)
+	fmt.Printf("  stream-%d %q %s ~ %s (%s)\n", m.StreamId, m.CameraName, m.From, m.To, m.Timezone)
+	fmt.Printf("  signature=%t (%s key) verified=%d missing=%d altered=%d extra=%d\n",
+		report.SignatureValid, report.KeySource, report.Verified, len(report.Missing), len(report.Altered), len(report.Extra))
+	if report.KeySource == "embedded" {
+		fmt.Println("  error: the bundle can't be trusted with its own key; use --key to pin the server key")
+	}
+	for _, name := range report.Missing {
+		fmt.Printf("  missing  %s\n", name)
+	}
+	for _, name := range report.Altered {
+		fmt.Printf("  altered  %s\n", name)
+	}
+	for _, name := range report.Extra {
+		fmt.Printf("  extra    %s\n", name)
+	}
+}
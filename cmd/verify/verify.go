@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	appName        = "rtsp-verify"
+	appDisplayName = "RTSP Stream Verifier"
+	appVersion     = "1.0.0"
+)
+
+var (
+	fs       = pflag.NewFlagSet(appName, pflag.ContinueOnError)
+	addr     = fs.StringP("addr", "a", "http://127.0.0.1:8000", "Server address")
+	streamId = fs.Int64P("id", "i", 0, "Stream ID")
+	date     = fs.StringP("date", "d", "", "Date to verify (YYYYMMDD)")
+	token    = fs.StringP("token", "t", "", "API token with the media:read scope")
+	asJson   = fs.Bool("json", false, "Print the report as JSON")
+	bundle   = fs.StringP("bundle", "b", "", "Evidence bundle to verify offline")
+	key      = fs.StringP("key", "k", "", "Public key of the server (base64 or file) to verify the bundle")
+)
+
+func main() {
+	if len(*bundle) > 0 {
+		report, err := verifyBundle(*bundle, *key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		printBundleReport(*bundle, report)
+		if !report.Ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *streamId < 1 || len(*date) != len(common.DateFormat) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	report, err := requestVerification(*addr, *token, *streamId, *date)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	printReport(report)
+	if !report.Ok {
+		os.Exit(1)
+	}
+}
+
+func requestVerification(addr, token string, streamId int64, date string) (*common.VerificationReport, error) {
+	url := fmt.Sprintf("%s/videos/%d/date/%s/verify", strings.TrimSuffix(addr, "/"), streamId, date)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		if len(token) < 1 {
+			return nil, fmt.Errorf("%s: the server requires an API token; use --token", resp.Status)
+		}
+		return nil, fmt.Errorf("%s: the API token is invalid or has expired", resp.Status)
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("%s: the API token needs the media:read scope and access to stream-%d", resp.Status, streamId)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var result common.Result
+		if err := json.Unmarshal(body, &result); err == nil && len(result.Error) > 0 {
+			return nil, fmt.Errorf("%s: %s", resp.Status, result.Error)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	var report common.VerificationReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	return &report, nil
+}
+
+func printReport(report *common.VerificationReport) {
+	if *asJson {
+		b, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+
+	result := "OK"
+	if !report.Ok {
+		result = "FAILED"
+	}
+	fmt.Printf("stream-%d %s (%s): %s\n", report.StreamId, report.Date, report.Source, result)
+	fmt.Printf("  total=%d verified=%d unhashed=%d missing=%d altered=%d extra=%d\n",
+		report.Total, report.Verified, report.Unhashed, len(report.Missing), len(report.Altered), len(report.Extra))
+	for _, name := range report.Missing {
+		fmt.Printf("  missing  %s\n", name)
+	}
+	for _, name := range report.Altered {
+		fmt.Printf("  altered  %s\n", name)
+	}
+	for _, name := range report.Extra {
+		fmt.Printf("  extra    %s\n", name)
+	}
+}
+
+func init() {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s %s\n\nUsage: %s --id <stream id> --date <YYYYMMDD> [--addr <server address>] [--token <API token>]\n       %s --bundle <file> [--key <public key>]\n\n", appDisplayName, appVersion, appName, appName)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[1:])
+}
@@ -24,6 +24,13 @@ type Config struct {
 	HlsOptions        struct {
 		SegmentTime int
 	}
+	Archive    ArchiveOption    `json:"archive"`
+	Export     ExportOption     `json:"export"`
+	Quota      QuotaOption      `json:"quota"`
+	Tier       TierOption       `json:"tier"`
+	Watchdog   WatchdogOption   `json:"watchdog"`
+	Encryption EncryptionOption `json:"encryption"`
+	Auth       AuthOption       `json:"auth"`
 }
 
 func ReadConfig(path string) *Config {
@@ -44,6 +51,56 @@ func ReadConfig(path string) *Config {
 		config.DataRetentionDays = 1
 	}
 
+	if config.Archive.Interval < 1 || config.Archive.Interval > 24*60 {
+		config.Archive.Interval = 24 * 60
+	}
+
+	if config.Archive.Workers < 1 {
+		config.Archive.Workers = 1
+	}
+
+	if config.Archive.MaxAttempts < 1 {
+		config.Archive.MaxAttempts = 1
+	}
+
+	if len(config.Export.Dir) < 1 {
+		config.Export.Dir = "export"
+	}
+
+	if config.Export.Expiry < 1 {
+		config.Export.Expiry = 24
+	}
+
+	if config.Watchdog.Interval < 1 {
+		config.Watchdog.Interval = 30
+	}
+
+	if config.Watchdog.Critical <= 0 || config.Watchdog.Critical >= 100 {
+		config.Watchdog.Critical = 5
+	}
+
+	if config.Watchdog.Warning < config.Watchdog.Critical {
+		config.Watchdog.Warning = config.Watchdog.Critical
+	}
+
+	if config.Watchdog.Target <= config.Watchdog.Critical {
+		config.Watchdog.Target = config.Watchdog.Warning
+	}
+
+	if config.Tier.ColdAfterDays < 0 {
+		config.Tier.ColdAfterDays = 0
+	}
+
+	if config.Auth.SessionTimeout < 1 {
+		config.Auth.SessionTimeout = 12 * 60
+	}
+
+	for group, quota := range config.Quota.Groups {
+		if quota < 0 {
+			config.Quota.Groups[group] = 0
+		}
+	}
+
 	return config
 }
 
@@ -71,12 +128,63 @@ var DefaultConfig = Config{
 	BindAddress:       "0.0.0.0:8000",
 	StaticDir:         "static",
 	HlsOptions:        HlsOption{SegmentTime: 30},
+	Archive:           ArchiveOption{Interval: 24 * 60, Workers: 2, MaxAttempts: 3},
+	Export:            ExportOption{Dir: "export", Expiry: 24},
+	Watchdog:          WatchdogOption{Interval: 30, Warning: 15, Critical: 5, Target: 20},
+	Auth:              AuthOption{SessionTimeout: 12 * 60},
 }
 
 type HlsOption struct {
 	SegmentTime int
 }
 
+type ArchiveOption struct {
+	Interval    int // Archiving interval in minutes (default: once a day)
+	Workers     int // Number of jobs running at the same time
+	MaxAttempts int // Failed jobs are retried until
+}
+
+type ExportOption struct {
+	Dir    string // Directory of exported files
+	Expiry int    // Exported files are deleted after (hours)
+}
+
+type QuotaOption struct {
+	Groups map[string]int64 // Bytes of archived and live videos by stream group
+}
+
+// WatchdogOption has levels of free space and free inodes in percent
+type WatchdogOption struct {
+	Interval int     // seconds
+	Warning  float64 // Warning below
+	Critical float64 // Oldest videos are purged below
+	Target   float64 // Purging stops at (low-water mark)
+}
+
+type EncryptionOption struct {
+	Enabled bool   // Archived videos are encrypted with AES-256-GCM
+	KeyDir  string // Directory of master keys (default: {db dir}/keys)
+}
+
+type AuthOption struct {
+	SessionTimeout int    // Sessions expire after (minutes)
+	AdminPassword  string // Password of the initial admin (random if empty; printed in the log)
+}
+
+type TierOption struct {
+	ColdAfterDays int // Archived days are moved to the cold storage after (0: disabled)
+	Cold          ColdStorageOption
+}
+
+// ColdStorageOption is a S3 compatible object storage
+type ColdStorageOption struct {
+	Address   string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	UseSSL    bool
+}
+
 //func GetDefaultConfig() *Config {
 //    return &Config{
 //        Storage: struct {
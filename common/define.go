@@ -2,16 +2,17 @@ package common
 
 import (
 	"crypto/sha256"
-	"github.com/minio/minio-go"
+	"encoding/json"
 	"github.com/pkg/errors"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	Loc         *time.Location
-	MinioClient *minio.Client
-	HashKey     []byte
+	Loc     *time.Location
+	HashKey []byte
 )
 
 func init() {
@@ -39,6 +40,9 @@ const (
 	ContentTypeTs          = "video/MP2T"
 	ContentTypeM3u8        = "application/x-mpegURL"
 	ContentTypeOctetStream = "application/octet-stream"
+	ContentTypeMp4         = "video/mp4"
+	ContentTypeZip         = "application/zip"
+	ContentTypeVtt         = "text/vtt"
 	//ContentTypeM3u8 = "application/vnd.apple.mpegurl"
 
 	LiveBucketName = "live"
@@ -48,6 +52,7 @@ const (
 	VideoFileExt        = ".ts"
 
 	LiveM3u8FileName = "index.m3u8"
+	KeyframeFileName = "keyframes.json" // Keyframe index of archived segments of the day
 )
 
 var (
@@ -62,13 +67,165 @@ var (
 	VideoRecordBucket = "record"
 	//IndexM3u8         = "index.m3u8"
 	LastArchivingDateKey = []byte("lastRecordingDate")
+	LastArchivingTimeKey = []byte("lastArchivingTime") // unix time until which live videos have been archived
+	ShareKey             = []byte("shareKey")          // HMAC key of shared URLs
+
+	// Stream DB buckets
+	ArchiveBucket = []byte("archive") // date(sub-bucket) / file name / TransmissionResult
+	EventBucket   = []byte("event")   // unix nano time / StreamEvent
+
+	AnnotationBucket = []byte("annotation") // (stream DB) annotation id / Annotation
+
+	JobBucket = []byte("job") // job id / Job
+
+	UserBucket    = []byte("user")    // username / User
+	SessionBucket = []byte("session") // SHA-256 of session token / Session
+	TokenBucket   = []byte("token")   // SHA-256 of API token / ApiToken
+	ShareBucket   = []byte("share")   // share id / Share
+
+	ChainBucket       = []byte("chain") // (stream DB) date(sub-bucket) / sequence / ChainEntry
+	ChainBucketPrefix = "chain-"        // (main DB) date / ChainHead
+)
+
+// Job types
+const (
+	JobArchive   = "archive"
+	JobExport    = "export"
+	JobMove      = "move"      // moves archived days to the cold storage
+	JobTranscode = "transcode" // re-encodes archived days
+)
+
+// Levels of the disk watchdog
+const (
+	DiskOk       = "ok"
+	DiskWarning  = "warning"
+	DiskCritical = "critical"
+)
+
+// Codecs of transcoding policies
+const (
+	CodecH264 = "h264"
+	CodecH265 = "h265"
+)
+
+// Storage tiers of archived days
+const (
+	TierHot  = "hot"
+	TierCold = "cold"
+)
+
+// Export formats
+const (
+	ExportMp4    = "mp4"
+	ExportBundle = "bundle" // evidence bundle (zip)
+)
+
+// Files of evidence bundles
+const (
+	BundleManifestFileName  = "manifest.json"
+	BundleSignatureFileName = "manifest.sig" // base64 encoded ed25519 signature of manifest
+	BundlePublicKeyFileName = "public.key"   // base64 encoded ed25519 public key
+	BundleClipFileName      = "clip.mp4"
+	BundleSegmentDir        = "segments/"
+)
+
+// Job status
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// Roles of users; each role can do what the roles below it can
+const (
+	RoleViewer   = "viewer"   // live and playback
+	RoleOperator = "operator" // start, stop, export and annotations
+	RoleAdmin    = "admin"    // streams, settings and users
+)
+
+// RoleLevel returns the level of the role; 0 for an unknown role
+func RoleLevel(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Permissions on streams granted to restricted users
+const (
+	AclLive       = "live"
+	AclRecordings = "recordings" // playback, timeline and annotations
+	AclExport     = "export"     // exports and shares
+	AclControl    = "control"    // start, stop, archive and protection
+)
+
+var AclPermissions = []string{AclLive, AclRecordings, AclExport, AclControl}
+
+// Scopes of API tokens
+const (
+	ScopeStreamsRead      = "streams:read"
+	ScopeStreamsWrite     = "streams:write" // stream CRUD, start and stop
+	ScopeMediaRead        = "media:read"    // playlists, videos, timeline, annotations and verification
+	ScopeAnnotationsWrite = "annotations:write"
+	ScopeExport           = "export"
+	ScopeArchive          = "archive" // archive jobs and protection
+	ScopeSystemRead       = "system:read"
+)
+
+var Scopes = []string{ScopeStreamsRead, ScopeStreamsWrite, ScopeMediaRead, ScopeAnnotationsWrite, ScopeExport, ScopeArchive, ScopeSystemRead}
+
+// Reasons of stream state transitions
+const (
+	ReasonStopped      = "stopped"      // stopped by user
+	ReasonFfmpegExited = "ffmpegExited" // ffmpeg process has exited by itself
+	ReasonStalled      = "stalled"      // stopped by watcher because the stream wasn't updated
+	ReasonFailed       = "failed"       // failed to start
+	ReasonSchedule     = "schedule"     // stopped by manager (reloading, shutting down)
+	ReasonDisabled     = "disabled"
+	ReasonEnabled      = "enabled"
+	ReasonUnknown      = "unknown"
 )
 
 var (
-	ErrorInvalidUri       = errors.New("invalid URI")
-	ErrorDuplicatedStream = errors.New("duplicated stream")
-	ErrorInvalidStream    = errors.New("invalid stream")
-	ErrorStreamNotFound   = errors.New("stream not found")
+	ErrorInvalidUri         = errors.New("invalid URI")
+	ErrorDuplicatedStream   = errors.New("duplicated stream")
+	ErrorInvalidStream      = errors.New("invalid stream")
+	ErrorStreamNotFound     = errors.New("stream not found")
+	ErrorInvalidDate        = errors.New("invalid date")
+	ErrorInvalidTime        = errors.New("invalid time")
+	ErrorJobNotFound        = errors.New("job not found")
+	ErrorInvalidJobStatus   = errors.New("invalid job status")
+	ErrorNoVideos           = errors.New("no videos")
+	ErrorExpired            = errors.New("expired")
+	ErrorInvalidFormat      = errors.New("invalid format")
+	ErrorNoColdStorage      = errors.New("cold storage is not configured")
+	ErrorColdUnavailable    = errors.New("cold tier unavailable")
+	ErrorAlteredVideos      = errors.New("altered videos")
+	ErrorInvalidPolicy      = errors.New("invalid transcoding policy")
+	ErrorNoEncryption       = errors.New("encryption is not enabled")
+	ErrorInvalidAnnotation  = errors.New("invalid annotation")
+	ErrorAnnotationNotFound = errors.New("annotation not found")
+	ErrorInvalidUser        = errors.New("invalid user")
+	ErrorInvalidPassword    = errors.New("password must be at least 8 characters")
+	ErrorUserNotFound       = errors.New("user not found")
+	ErrorDuplicatedUser     = errors.New("duplicated user")
+	ErrorLastAdmin          = errors.New("the last admin can't be deleted or demoted")
+	ErrorLoginFailed        = errors.New("invalid username or password")
+	ErrorUnauthorized       = errors.New("unauthorized")
+	ErrorForbidden          = errors.New("forbidden")
+	ErrorInvalidToken       = errors.New("invalid token")
+	ErrorTokenNotFound      = errors.New("token not found")
+	ErrorInvalidAcl         = errors.New("invalid ACL")
+	ErrorInvalidShare       = errors.New("invalid share")
+	ErrorShareNotFound      = errors.New("share not found")
+	ErrorInvalidSignature   = errors.New("invalid or expired signature")
 )
 
 type StreamKey struct {
@@ -111,12 +268,14 @@ func NewVideoFile(f os.FileInfo, dir string) *VideoFile {
 	}
 }
 
+// TransmissionResult describes a file that has been stored in the record store
 type TransmissionResult struct {
-	StreamId int64
-	Seq      int
-	Hash     []byte
-	Size     int64
-	Date     string
+	StreamId int64  `json:"streamId"`
+	Seq      int    `json:"seq"`
+	Name     string `json:"name"`
+	Hash     []byte `json:"hash"`
+	Size     int64  `json:"size"`
+	Date     string `json:"date"`
 }
 
 func NewTransmissionResult(streamId int64, seq int, size int64, hash []byte, date string) *TransmissionResult {
@@ -153,12 +312,23 @@ func NewProtocolInfo(protocol int) *ProtocolInfo {
 }
 
 type Segment struct {
-	SeqId    int64   `json:"id"`
-	Duration float64 `json:"d"`
-	URI      string  `json:"uri"`
-	UnixTime int64   `json:"t"`
-	Data     []byte  `json:"-"`
-	Date     string  `json:"date"`
+	SeqId     int64       `json:"id"`
+	Duration  float64     `json:"d"`
+	URI       string      `json:"uri"`
+	UnixTime  int64       `json:"t"`
+	Data      []byte      `json:"-"`
+	Date      string      `json:"date"`
+	Size      int64       `json:"size"`
+	Hash      string      `json:"hash"` // Highwayhash of the segment file (hex)
+	Keyframes []*Keyframe `json:"keyframes,omitempty"`
+}
+
+// Keyframe is the byte range of an I-frame in a segment
+type Keyframe struct {
+	Time     float64 `json:"t"` // seconds from the beginning of the segment
+	Duration float64 `json:"d"` // seconds until the next keyframe
+	Offset   int64   `json:"o"`
+	Length   int64   `json:"l"`
 }
 
 func NewSegment(seqId int64, duration float64, uri string, modTime time.Time) *Segment {
@@ -175,6 +345,418 @@ type Record struct {
 	Id int64
 }
 
+type StreamEvent struct {
+	Time   int64  `json:"t"`
+	Status int    `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func NewStreamEvent(t time.Time, status int, reason string) *StreamEvent {
+	return &StreamEvent{
+		Time:   t.Unix(),
+		Status: status,
+		Reason: reason,
+	}
+}
+
+type TimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+type TimelineGap struct {
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// Timeline describes recorded ranges and gaps of a stream over a time range
+type Timeline struct {
+	StreamId    int64          `json:"streamId"`
+	From        int64          `json:"from"`
+	To          int64          `json:"to"`
+	Ranges      []*TimeRange   `json:"ranges"`
+	Gaps        []*TimelineGap `json:"gaps"`
+	Events      []*StreamEvent `json:"events"`
+	Annotations []*Annotation  `json:"annotations"`
+}
+
+func NewTimeline(streamId int64, from, to time.Time) *Timeline {
+	return &Timeline{
+		StreamId:    streamId,
+		From:        from.Unix(),
+		To:          to.Unix(),
+		Ranges:      make([]*TimeRange, 0),
+		Gaps:        make([]*TimelineGap, 0),
+		Events:      make([]*StreamEvent, 0),
+		Annotations: make([]*Annotation, 0),
+	}
+}
+
+// User is an account of the web UI and API
+type User struct {
+	Username   string      `json:"username"`
+	Password   []byte      `json:"password,omitempty"` // bcrypt hash; omitted in responses
+	Role       string      `json:"role"`
+	Restricted bool        `json:"restricted"` // can access only the streams granted by the ACL (ignored for admins)
+	Acl        []*AclEntry `json:"acl"`
+	Created    int64       `json:"created"`
+	LastLogin  int64       `json:"lastLogin"`
+}
+
+// AclEntry grants permissions on a stream or on the streams of a group
+type AclEntry struct {
+	StreamId    int64    `json:"streamId,omitempty"`
+	Group       string   `json:"group,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// Allows checks if the user can do the action on the stream of the group; an empty action means any permission
+func (u *User) Allows(streamId int64, group, action string) bool {
+	if !u.Restricted || u.Role == RoleAdmin {
+		return true
+	}
+	for _, e := range u.Acl {
+		if e.StreamId != streamId && (len(e.Group) < 1 || e.Group != group) {
+			continue
+		}
+		for _, p := range e.Permissions {
+			if len(action) < 1 || p == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Session is a login session of the web UI
+type Session struct {
+	Username string `json:"username"`
+	Created  int64  `json:"created"`
+	Expires  int64  `json:"expires"`
+}
+
+// ApiToken is a bearer token of machine clients; the token itself is not stored
+type ApiToken struct {
+	Id        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Streams   []int64  `json:"streams"` // empty: all streams
+	Expires   int64    `json:"expires"` // unix time (0: never)
+	Created   int64    `json:"created"`
+	CreatedBy string   `json:"createdBy"`
+	LastUsed  int64    `json:"lastUsed"`
+}
+
+func (t *ApiToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Share is a live view or a recorded range shared by signed URLs without accounts
+type Share struct {
+	Id        int64  `json:"id"`
+	StreamId  int64  `json:"streamId"`
+	Live      bool   `json:"live"`
+	From      int64  `json:"from"` // unix time of the recorded range
+	To        int64  `json:"to"`
+	Expires   int64  `json:"expires"` // unix time
+	Created   int64  `json:"created"`
+	CreatedBy string `json:"createdBy"`
+	Revoked   int64  `json:"revoked"` // unix time (0: not revoked)
+}
+
+// Annotation is a note attached to a point or range in time of a stream by operators or external systems
+type Annotation struct {
+	Id       int64    `json:"id"`
+	StreamId int64    `json:"streamId"`
+	From     int64    `json:"from"` // unix time
+	To       int64    `json:"to"`   // same as "from" for a point in time
+	Label    string   `json:"label"`
+	Text     string   `json:"text"`
+	Tags     []string `json:"tags"`
+	Author   string   `json:"author"`
+	Source   string   `json:"source"` // operator, access-control, pos, ..
+	Created  int64    `json:"created"`
+	Updated  int64    `json:"updated"`
+}
+
+// AnnotationFilter selects annotations; empty fields match all
+type AnnotationFilter struct {
+	From   int64
+	To     int64
+	Tag    string
+	Source string
+	Author string
+	Query  string // text in label or text
+}
+
+func (f *AnnotationFilter) Match(a *Annotation) bool {
+	if (f.From > 0 && a.To < f.From) || (f.To > 0 && a.From > f.To) {
+		return false
+	}
+	if len(f.Source) > 0 && a.Source != f.Source {
+		return false
+	}
+	if len(f.Author) > 0 && a.Author != f.Author {
+		return false
+	}
+	if len(f.Tag) > 0 {
+		found := false
+		for _, tag := range a.Tags {
+			if tag == f.Tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Query) > 0 {
+		q := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(a.Label), q) && !strings.Contains(strings.ToLower(a.Text), q) {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncPlayback has playlists of streams aligned to the same wall-clock time
+type SyncPlayback struct {
+	From    int64         `json:"from"`
+	To      int64         `json:"to"`
+	Streams []*SyncStream `json:"streams"`
+}
+
+type SyncStream struct {
+	Id       int64        `json:"id"`
+	Name     string       `json:"name"`
+	Playlist string       `json:"playlist"` // URL of the padded playlist
+	Offset   float64      `json:"offset"`   // seconds from the beginning of the playlist to "from"
+	Ranges   []*TimeRange `json:"ranges"`   // recorded ranges
+}
+
+// Verification sources
+const (
+	VerifyLive    = "live"
+	VerifyArchive = "archive"
+)
+
+// VerificationReport is the result of comparing stored files with their recorded hashes
+type VerificationReport struct {
+	StreamId int64    `json:"streamId"`
+	Date     string   `json:"date"`
+	Source   string   `json:"source"`   // live or archive
+	Total    int      `json:"total"`    // number of recorded files
+	Verified int      `json:"verified"` // number of files whose hash matched
+	Unhashed int      `json:"unhashed"` // number of records without hash (recorded by old versions)
+	Missing  []string `json:"missing"`  // recorded but not found
+	Altered  []string `json:"altered"`  // found but hash or size differs
+	Extra    []string `json:"extra"`    // found but not recorded
+	Ok       bool     `json:"ok"`
+}
+
+func NewVerificationReport(streamId int64, date, source string) *VerificationReport {
+	return &VerificationReport{
+		StreamId: streamId,
+		Date:     date,
+		Source:   source,
+		Missing:  make([]string, 0),
+		Altered:  make([]string, 0),
+		Extra:    make([]string, 0),
+	}
+}
+
+type Job struct {
+	Id          int64      `json:"id"`
+	Type        string     `json:"type"`
+	StreamId    int64      `json:"streamId"`
+	From        int64      `json:"from"` // unix time
+	To          int64      `json:"to"`
+	Format      string     `json:"format,omitempty"` // export format
+	Status      string     `json:"status"`
+	Stage       string     `json:"stage"` // last checkpoint of the running job
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"maxAttempts"`
+	Error       string     `json:"error"`
+	Created     int64      `json:"created"`
+	Started     int64      `json:"started"`
+	Finished    int64      `json:"finished"`
+	Duration    float64    `json:"duration"`  // seconds
+	NotBefore   int64      `json:"notBefore"` // retried jobs wait until
+	Result      *JobResult `json:"result"`
+}
+
+type JobResult struct {
+	InputFiles     int     `json:"inputFiles"`
+	InputBytes     int64   `json:"inputBytes"`
+	OutputFiles    int     `json:"outputFiles"`
+	OutputBytes    int64   `json:"outputBytes"`
+	SourceDuration float64 `json:"sourceDuration"` // seconds
+	OutputDuration float64 `json:"outputDuration"`
+	Output         string  `json:"output"`            // output of ffmpeg when failed
+	File           string  `json:"file,omitempty"`    // exported file
+	Expires        int64   `json:"expires,omitempty"` // unix time when the exported file is deleted
+}
+
+func NewJob(jobType string, streamId int64, from, to time.Time, maxAttempts int) *Job {
+	return &Job{
+		Type:        jobType,
+		StreamId:    streamId,
+		From:        from.Unix(),
+		To:          to.Unix(),
+		Status:      JobQueued,
+		MaxAttempts: maxAttempts,
+		Created:     time.Now().Unix(),
+	}
+}
+
+// Key identifies jobs which must be run one at a time in order
+func (j *Job) Key() string {
+	return j.Type + "-" + strconv.FormatInt(j.StreamId, 10)
+}
+
+func (j *Job) IsFinished() bool {
+	return j.Status == JobDone || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+type ArchiveProgress struct {
+	StreamId      int64 `json:"streamId"`
+	Queued        int   `json:"queued"`
+	Running       int   `json:"running"`
+	Done          int   `json:"done"`
+	Failed        int   `json:"failed"`
+	ArchivedUntil int64 `json:"archivedUntil"` // unix time
+	Current       *Job  `json:"current"`
+}
+
+// DayArchive is the value of "video-{id}" buckets; previous versions saved only the size(int64)
+type DayArchive struct {
+	Size       int64  `json:"size"`
+	Tier       string `json:"tier"`
+	Moved      int64  `json:"moved,omitempty"`      // unix time
+	Transcoded int64  `json:"transcoded,omitempty"` // unix time
+	Protected  bool   `json:"protected,omitempty"`  // not evicted by quota, retention and the disk watchdog
+}
+
+// TranscodePolicy re-encodes archived days older than AfterDays
+type TranscodePolicy struct {
+	AfterDays   int    `json:"afterDays"`   // 0: disabled
+	Codec       string `json:"codec"`       // h264 or h265
+	Height      int    `json:"height"`      // 0: original resolution
+	BitrateKbps int    `json:"bitrateKbps"` // 0: constant quality
+}
+
+func (p *TranscodePolicy) IsEnabled() bool {
+	return p != nil && p.AfterDays > 0
+}
+
+func ParseDayArchive(data []byte) *DayArchive {
+	day := &DayArchive{Tier: TierHot}
+	if len(data) == 8 {
+		day.Size = BytesToInt64(data)
+		return day
+	}
+	if err := json.Unmarshal(data, day); err != nil || len(day.Tier) < 1 {
+		day.Tier = TierHot
+	}
+	return day
+}
+
+type DiskStatus struct {
+	Path              string  `json:"path"`
+	TotalBytes        uint64  `json:"totalBytes"`
+	FreeBytes         uint64  `json:"freeBytes"`
+	FreePercent       float64 `json:"freePercent"`
+	Inodes            uint64  `json:"inodes"`
+	FreeInodes        uint64  `json:"freeInodes"`
+	FreeInodesPercent float64 `json:"freeInodesPercent"` // 100 if inodes are not available
+	Level             string  `json:"level"`
+}
+
+type WatchdogStatus struct {
+	Level     string        `json:"level"`
+	Dirs      []*DiskStatus `json:"dirs"`
+	Checked   int64         `json:"checked"`   // unix time
+	LastPurge int64         `json:"lastPurge"` // unix time
+	Purged    []string      `json:"purged"`    // record keys and files deleted by the last purge
+	Error     string        `json:"error,omitempty"`
+}
+
+type StreamUsage struct {
+	StreamId      int64  `json:"streamId"`
+	Group         string `json:"group"`
+	ArchivedBytes int64  `json:"archivedBytes"`
+	LiveBytes     int64  `json:"liveBytes"`
+	UsedBytes     int64  `json:"usedBytes"`
+	QuotaBytes    int64  `json:"quotaBytes"` // 0: unlimited
+	OldestDate    string `json:"oldestDate"`
+}
+
+type GroupUsage struct {
+	Group      string  `json:"group"`
+	Streams    []int64 `json:"streams"`
+	UsedBytes  int64   `json:"usedBytes"`
+	QuotaBytes int64   `json:"quotaBytes"` // 0: unlimited
+}
+
+type Usage struct {
+	Streams []*StreamUsage `json:"streams"`
+	Groups  []*GroupUsage  `json:"groups"`
+}
+
+type BundleFile struct {
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Sha256   string  `json:"sha256"`
+	Start    string  `json:"start,omitempty"` // RFC3339
+	Duration float64 `json:"duration,omitempty"`
+}
+
+type BundleManifest struct {
+	Version    int           `json:"version"`
+	StreamId   int64         `json:"streamId"`
+	CameraName string        `json:"cameraName"`
+	UriHash    string        `json:"uriHash"`
+	From       string        `json:"from"` // RFC3339
+	To         string        `json:"to"`
+	Timezone   string        `json:"timezone"`
+	Created    string        `json:"created"`
+	Clip       *BundleFile   `json:"clip"`
+	Segments   []*BundleFile `json:"segments"`
+}
+
+// ChainEntry links a segment record to the previous entry
+type ChainEntry struct {
+	Key        string `json:"key"`        // key of the segment record
+	RecordHash string `json:"recordHash"` // SHA-256 of the segment record
+	Prev       string `json:"prev"`
+	Hash       string `json:"hash"` // SHA-256(prev + key + recordHash)
+}
+
+type ChainHead struct {
+	Date     string `json:"date"`
+	Count    int64  `json:"count"`
+	Hash     string `json:"hash"`
+	Anchored int64  `json:"anchored"` // unix time
+}
+
+type ChainStatus struct {
+	StreamId  int64      `json:"streamId"`
+	Date      string     `json:"date"`
+	Count     int64      `json:"count"`
+	Head      string     `json:"head"`
+	Anchor    *ChainHead `json:"anchor"`
+	Unchained int        `json:"unchained"` // segment records not in the chain
+	Valid     bool       `json:"valid"`
+	Errors    []string   `json:"errors"`
+}
+
 type DayRecordMap map[string]map[string]string // rename to dailyVideoMap
 
 type TplGlobalVar struct {
@@ -12,7 +12,9 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func BytesToInt64(buf []byte) int64 {
@@ -72,6 +74,41 @@ func ReadVideoFilesOnDateInDir(dir, date, ext string) ([]os.FileInfo, error) {
 	return files, nil
 }
 
+// ReadVideoFilesInTimeRange returns video files modified in [from, to)
+func ReadVideoFilesInTimeRange(dir string, from, to time.Time, ext string) ([]os.FileInfo, error) {
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 0)
+	for _, f := range list {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		if f.Size() < 1 {
+			continue
+		}
+
+		if !strings.HasSuffix(f.Name(), ext) {
+			continue
+		}
+
+		if f.ModTime().Before(from) || !f.ModTime().Before(to) {
+			continue
+		}
+
+		files = append(files, f)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	return files, nil
+}
+
 //func ReadVideoFilesInDirNotOnDate(dir, date, ext string) ([]os.FileInfo, error) {
 //	list, err := ioutil.ReadDir(dir)
 //	if err != nil {
@@ -183,7 +220,17 @@ func SortDayRecord(dayRecordMap DayRecordMap) []map[string]string {
 	return values
 }
 
+// DetectContentType returns the content type by the extension of the name.
+// Videos and playlists are not detected by mime, because ".ts" is registered as a translation file on some systems.
 func DetectContentType(ext string) string {
+	switch strings.ToLower(filepath.Ext(ext)) {
+	case VideoFileExt:
+		return ContentTypeTs
+	case ".m3u8":
+		return ContentTypeM3u8
+	case ".mp4":
+		return ContentTypeMp4
+	}
 	ctype := mime.TypeByExtension(filepath.Ext(ext))
 	if ctype == "" {
 		return ContentTypeOctetStream
@@ -195,3 +242,32 @@ func GetHashString(str string) string {
 	hash := highwayhash.Sum128([]byte(str), HashKey)
 	return hex.EncodeToString(hash[:])
 }
+
+// ParseTime parses unix time, RFC3339 or date(YYYYMMDD) string
+func ParseTime(str string) (time.Time, error) {
+	if len(str) == len(DateFormat) {
+		if t, err := time.ParseInLocation(DateFormat, str, Loc); err == nil {
+			return t, nil
+		}
+	}
+	if unix, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(unix, 0).In(Loc), nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, ErrorInvalidTime
+	}
+	return t.In(Loc), nil
+}
+
+// GetDatesBetween returns dates(YYYYMMDD) from "from" to "to"
+func GetDatesBetween(from, to time.Time) []string {
+	dates := make([]string, 0)
+	from, to = from.In(Loc), to.In(Loc)
+	d := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, Loc)
+	for !d.After(to) {
+		dates = append(dates, d.Format(DateFormat))
+		d = d.AddDate(0, 0, 1)
+	}
+	return dates
+}
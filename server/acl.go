@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"strings"
+)
+
+func isValidAclPermission(permission string) bool {
+	for _, p := range common.AclPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAcl validates the entries; each entry has either a stream or a group
+func (m *Manager) normalizeAcl(acl []*common.AclEntry) ([]*common.AclEntry, error) {
+	list := make([]*common.AclEntry, 0, len(acl))
+	for _, e := range acl {
+		if e == nil {
+			continue
+		}
+		e.Group = strings.TrimSpace(e.Group)
+		if (e.StreamId > 0) == (len(e.Group) > 0) || e.StreamId < 0 || len(e.Permissions) < 1 {
+			return nil, common.ErrorInvalidAcl
+		}
+		for _, p := range e.Permissions {
+			if !isValidAclPermission(p) {
+				return nil, common.ErrorInvalidAcl
+			}
+		}
+		if e.StreamId > 0 && m.getStreamById(e.StreamId) == nil {
+			return nil, common.ErrorStreamNotFound
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// setUserAcl replaces the ACL of the user. It takes effect on the next request of the user.
+func (m *Manager) setUserAcl(username string, restricted bool, acl []*common.AclEntry) (*common.User, error) {
+	acl, err := m.normalizeAcl(acl)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *common.User
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.UserBucket)
+		data := b.Get([]byte(username))
+		if data == nil {
+			return common.ErrorUserNotFound
+		}
+		if err := json.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		user.Restricted = restricted
+		user.Acl = acl
+		return putUser(b, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Password = nil
+	return user, nil
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxAnnotationLabel = 256
+	maxAnnotationText  = 4096
+	maxAnnotationTags  = 32
+
+	// Source of annotations without source
+	annotationSourceOperator = "operator"
+
+	// CLASS of EXT-X-DATERANGE
+	annotationDateRangeClass = "com.devplayg.rtsp-stream.annotation"
+)
+
+// normalizeAnnotation validates the annotation and trims its fields
+func normalizeAnnotation(a *common.Annotation) error {
+	a.Label = strings.TrimSpace(a.Label)
+	a.Source = strings.TrimSpace(a.Source)
+	a.Author = strings.TrimSpace(a.Author)
+	if a.To == 0 {
+		a.To = a.From
+	}
+	if a.From < 1 || a.To < a.From {
+		return common.ErrorInvalidTime
+	}
+	if len(a.Label) < 1 || len(a.Label) > maxAnnotationLabel || len(a.Text) > maxAnnotationText || len(a.Tags) > maxAnnotationTags {
+		return common.ErrorInvalidAnnotation
+	}
+	if len(a.Source) < 1 {
+		a.Source = annotationSourceOperator
+	}
+
+	tags := make([]string, 0, len(a.Tags))
+	found := make(map[string]bool)
+	for _, tag := range a.Tags {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 1 || found[tag] {
+			continue
+		}
+		found[tag] = true
+		tags = append(tags, tag)
+	}
+	a.Tags = tags
+	return nil
+}
+
+func (m *Manager) addAnnotation(streamId int64, a *common.Annotation) error {
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return common.ErrorStreamNotFound
+	}
+	if err := normalizeAnnotation(a); err != nil {
+		return err
+	}
+	return stream.AddAnnotation(a)
+}
+
+func (m *Manager) getAnnotation(streamId, id int64) (*common.Annotation, error) {
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+	return stream.GetAnnotation(id)
+}
+
+func (m *Manager) updateAnnotation(streamId, id int64, a *common.Annotation) error {
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return common.ErrorStreamNotFound
+	}
+	if err := normalizeAnnotation(a); err != nil {
+		return err
+	}
+	a.Id = id
+	return stream.UpdateAnnotation(a)
+}
+
+func (m *Manager) deleteAnnotation(streamId, id int64) error {
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return common.ErrorStreamNotFound
+	}
+	return stream.DeleteAnnotation(id)
+}
+
+// searchAnnotations returns annotations of the streams matched by the filter; all streams are searched if ids are empty
+func (m *Manager) searchAnnotations(ids []int64, filter *common.AnnotationFilter) ([]*common.Annotation, error) {
+	streams := make([]*streaming.Stream, 0)
+	if len(ids) < 1 {
+		streams = m.getStreams()
+	}
+	for _, id := range ids {
+		stream := m.getStreamById(id)
+		if stream == nil {
+			return nil, common.ErrorStreamNotFound
+		}
+		streams = append(streams, stream)
+	}
+
+	list := make([]*common.Annotation, 0)
+	for _, s := range streams {
+		if s.DB == nil {
+			continue
+		}
+		annotations, err := s.GetAnnotations(filter)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, annotations...)
+	}
+	return list, nil
+}
+
+// deleteOldAnnotations deletes annotations which ended before the retention of the stream.
+// Annotations on protected days are kept with their videos.
+func (m *Manager) deleteOldAnnotations(s *streaming.Stream, t time.Time) error {
+	if s.DB == nil {
+		return nil
+	}
+	days, err := m.getArchivedDays(s)
+	if err != nil {
+		return err
+	}
+	protected := make(map[string]bool)
+	for _, d := range days {
+		if d.protected {
+			protected[d.date] = true
+		}
+	}
+
+	count, err := s.DeleteAnnotationsBefore(t, func(a *common.Annotation) bool {
+		from := time.Unix(a.From, 0).In(common.Loc)
+		to := time.Unix(a.To, 0).In(common.Loc)
+		for _, date := range common.GetDatesBetween(from, to) {
+			if protected[date] {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.WithFields(log.Fields{
+			"streamId": s.Id,
+			"count":    count,
+		}).Debug("[manager] old annotations have been deleted")
+	}
+	return nil
+}
+
+// getAnnotationDateRanges returns annotations in the time range as EXT-X-DATERANGE tags
+func (m *Manager) getAnnotationDateRanges(streamId int64, from, to time.Time) ([]*streaming.DateRange, error) {
+	annotations, err := m.searchAnnotations([]int64{streamId}, &common.AnnotationFilter{From: from.Unix(), To: to.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*streaming.DateRange, 0, len(annotations))
+	for _, a := range annotations {
+		d := &streaming.DateRange{
+			Id:    fmt.Sprintf("annotation-%d-%d", a.StreamId, a.Id),
+			Class: annotationDateRangeClass,
+			Start: time.Unix(a.From, 0).In(common.Loc),
+			Attributes: map[string]string{
+				"label":  a.Label,
+				"text":   a.Text,
+				"tags":   strings.Join(a.Tags, ","),
+				"author": a.Author,
+				"source": a.Source,
+			},
+		}
+		if a.To > a.From {
+			d.End = time.Unix(a.To, 0).In(common.Loc)
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// getAnnotationVtt returns annotations in the time range as WebVTT cues.
+// Cue times are relative to the beginning of the time range playlist, so it can be added as a text track of it.
+func (m *Manager) getAnnotationVtt(streamId int64, from, to time.Time) ([]byte, error) {
+	start := from
+	playlist, err := m.getRangePlaylist(streamId, from, to)
+	if err != nil && err != common.ErrorNoVideos {
+		return nil, err
+	}
+	if playlist != nil && len(playlist.Segments) > 0 {
+		start = playlist.Segments[0].Time
+	}
+	annotations, err := m.searchAnnotations([]int64{streamId}, &common.AnnotationFilter{From: from.Unix(), To: to.Unix()})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n\n")
+	for _, a := range annotations {
+		begin := time.Unix(a.From, 0).Sub(start)
+		end := time.Unix(a.To, 0).Sub(start)
+		if end-begin < time.Second {
+			end = begin + time.Second
+		}
+		if begin < 0 {
+			begin = 0
+		}
+		if end <= begin {
+			continue
+		}
+		buf.WriteString(strconv.FormatInt(a.Id, 10) + "\n")
+		buf.WriteString(formatVttTime(begin) + " --> " + formatVttTime(end) + "\n")
+		buf.WriteString(escapeVtt(a.Label) + "\n")
+		if text := strings.TrimSpace(a.Text); len(text) > 0 {
+			buf.WriteString(escapeVtt(text) + "\n")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func formatVttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// escapeVtt escapes the cue text; blank lines would end the cue
+func escapeVtt(str string) string {
+	str = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(str)
+	lines := make([]string, 0)
+	for _, line := range strings.Split(str, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
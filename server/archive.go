@@ -1,98 +1,254 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/devplayg/hippo"
 	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
 	"github.com/devplayg/rtsp-stream/streaming"
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func (m *Manager) canArchive() bool {
-	m.RLock()
-	defer m.RUnlock()
-	if m.onArchiving {
-		return false
+// Live videos modified within this period after the end of the window may still be being written
+const archivingGracePeriod = 1 * time.Minute
+
+// Checkpoint of archive and transcode jobs; the job only has to delete live videos or replaced segments when resumed
+const archiveStagePublished = "published"
+
+// Allowed difference between durations of live videos and merged videos
+const archiveDurationTolerance = 2.0 // seconds
+
+// archiveWindow is a period of live videos to be archived at once. It never spans midnight.
+type archiveWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func newArchiveWindow(from time.Time, interval time.Duration) *archiveWindow {
+	from = from.In(common.Loc)
+	midnight := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, common.Loc)
+	nextMidnight := midnight.AddDate(0, 0, 1)
+	to := midnight.Add((from.Sub(midnight)/interval + 1) * interval)
+	if to.After(nextMidnight) {
+		to = nextMidnight
 	}
-	m.onArchiving = true
-	return true
+	return &archiveWindow{From: from, To: to}
+}
+
+func (w *archiveWindow) Date() string {
+	return w.From.In(common.Loc).Format(common.DateFormat)
+}
+
+// isEndOfDay returns true if the window is the last one of the day
+func (w *archiveWindow) isEndOfDay() bool {
+	from := w.From.In(common.Loc)
+	return !w.To.Before(time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, common.Loc))
 }
 
 func (m *Manager) testScheduler() error {
-	targetDate := time.Now().In(common.Loc).Add(-24 * time.Hour).Format(common.DateFormat) // Yesterday
-	if err := m.startToArchiveVideos(targetDate); err != nil {
-		log.Error(err)
-		return err
-	}
-	return nil
+	return m.archivePendingVideos()
 }
 
 func (m *Manager) startScheduler() error {
-	scheduler := cron.New(cron.WithLocation(common.Loc))
+	scheduler := cron.New(cron.WithLocation(common.Loc), cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger)))
 
-	_, err := scheduler.AddFunc("10 0 * * *", func() {
-		if !m.canArchive() {
-			log.Debug("archiving is already running")
-			return
+	// Closed archiving windows are checked every minute and queued as jobs; missed windows are caught up as well.
+	_, err := scheduler.AddFunc("* * * * *", func() {
+		if err := m.archivePendingVideos(); err != nil {
+			log.Error(err)
 		}
-		defer func() {
-			m.RLock()
-			m.onArchiving = false
-			m.RUnlock()
-		}()
+	})
+	if err != nil {
+		return err
+	}
 
-		targetDate := time.Now().In(common.Loc).Add(-24 * time.Hour).Format(common.DateFormat) // Yesterday
-		if err := m.startToArchiveVideos(targetDate); err != nil {
+	// Retention is checked every hour since it can be set in hours by stream
+	_, err = scheduler.AddFunc("10 * * * *", func() {
+		if err := m.deleteOldData(); err != nil {
 			log.Error(err)
 			return
 		}
+		if _, err := m.jobs.prune(time.Now().AddDate(0, 0, -m.server.config.DataRetentionDays)); err != nil {
+			log.Error(err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = scheduler.AddFunc("20 * * * *", func() {
+		if err := m.enforceQuotas(); err != nil {
+			log.Error(err)
+		}
+	})
+	if err != nil {
+		return err
+	}
 
-		if err := m.deleteOldData(m.server.config.DataRetentionDays); err != nil {
+	// Old archived days are moved to the cold storage
+	_, err = scheduler.AddFunc("30 0 * * *", func() {
+		if err := m.addMoveJobs(); err != nil {
+			log.Error(err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Old archived days are re-encoded by the policy of each stream
+	_, err = scheduler.AddFunc("40 0 * * *", func() {
+		if err := m.addTranscodeJobs(); err != nil {
+			log.Error(err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Chain heads of the closed days are anchored in the main database
+	_, err = scheduler.AddFunc("15 0 * * *", func() {
+		if err := m.anchorChainHeads(); err != nil {
 			log.Error(err)
-			return
 		}
+	})
+	if err != nil {
+		return err
+	}
 
+	_, err = scheduler.AddFunc("5 * * * *", func() {
+		if err := m.deleteExpiredExports(); err != nil {
+			log.Error(err)
+		}
 	})
+	if err != nil {
+		return err
+	}
 
 	scheduler.Start()
 	m.scheduler = scheduler
 
-	return err
+	return nil
 }
 
-func (m *Manager) startToArchiveVideos(targetDate string) error {
-	t := time.Now()
+func (m *Manager) getArchiveInterval() time.Duration {
+	return time.Duration(m.server.config.Archive.Interval) * time.Minute
+}
+
+func (m *Manager) getLastArchivingTime(t time.Time) (time.Time, error) {
+	val, err := GetValueFromDbBucket(common.ConfigBucket, common.LastArchivingTimeKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(val) == 8 {
+		return time.Unix(common.BytesToInt64(val), 0).In(common.Loc), nil
+	}
+
+	// Archived once a day by previous versions
+	val, err = GetValueFromDbBucket(common.ConfigBucket, common.LastArchivingDateKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if val != nil {
+		if d, err := time.ParseInLocation(common.DateFormat, string(val), common.Loc); err == nil {
+			return d.AddDate(0, 0, 1), nil
+		}
+	}
+
+	t = t.In(common.Loc)
+	return time.Date(t.Year(), t.Month(), t.Day()-7, 0, 0, 0, 0, common.Loc), nil
+}
+
+func (m *Manager) setLastArchivingTime(w *archiveWindow) error {
+	if err := PutDataIntoDbBucket(common.ConfigBucket, common.LastArchivingTimeKey, common.Int64ToBytes(w.To.Unix())); err != nil {
+		return err
+	}
+	if !w.isEndOfDay() {
+		return nil
+	}
+	return PutDataIntoDbBucket(common.ConfigBucket, common.LastArchivingDateKey, []byte(w.Date()))
+}
+
+// getPendingArchiveWindows returns the closed windows which haven't been archived yet
+func (m *Manager) getPendingArchiveWindows(t time.Time) ([]*archiveWindow, error) {
+	last, err := m.getLastArchivingTime(t)
+	if err != nil {
+		return nil, err
+	}
+	if last.After(t) {
+		return nil, errors.New("invalid system time on scheduler")
+	}
+
+	interval := m.getArchiveInterval()
+	windows := make([]*archiveWindow, 0)
+	for from := last; ; {
+		w := newArchiveWindow(from, interval)
+		if w.To.Add(archivingGracePeriod).After(t) {
+			break
+		}
+		windows = append(windows, w)
+		from = w.To
+	}
+	return windows, nil
+}
+
+func (m *Manager) archivePendingVideos() error {
+	windows, err := m.getPendingArchiveWindows(time.Now().In(common.Loc))
+	if err != nil {
+		return err
+	}
+	if len(windows) > 1 {
+		log.WithFields(log.Fields{
+			"from":  windows[0].From.Format(time.RFC3339),
+			"count": len(windows),
+		}).Debug("[manager] handling missed archiving tasks")
+	}
+
+	for _, w := range windows {
+		if err := m.startToArchiveVideos(w); err != nil {
+			return err
+		}
+		if err := m.setLastArchivingTime(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startToArchiveVideos queues archive jobs of the window and deletes videos not to be archived
+func (m *Manager) startToArchiveVideos(w *archiveWindow) error {
 	streamIdListToArchive, streamIdListNotToArchive := m.getStreamIdListToArchive()
 	log.WithFields(log.Fields{
-		"targetDate":               targetDate,
+		"from":                     w.From.Format(time.RFC3339),
+		"to":                       w.To.Format(time.RFC3339),
 		"streamsCountToArchive":    len(streamIdListToArchive),
 		"streamsCountNotToArchive": len(streamIdListNotToArchive),
 	}).Debug("[manager] archiving is about to start")
 
-	if len(streamIdListToArchive) > 0 {
-		if err := m.startToArchiveVideosOnDate(streamIdListToArchive, targetDate); err != nil {
-			log.Error("failed to archive videos")
-			return err
-		}
+	jobs := make([]*common.Job, 0, len(streamIdListToArchive))
+	for _, streamId := range streamIdListToArchive {
+		jobs = append(jobs, common.NewJob(common.JobArchive, streamId, w.From, w.To, m.server.config.Archive.MaxAttempts))
 	}
-	if err := m.startToDeleteVideosNotToBeArchived(streamIdListNotToArchive, targetDate); err != nil {
-		log.Error("failed to delete videos; " + err.Error())
+	if err := m.jobs.add(jobs...); err != nil {
+		return err
 	}
 
-	log.WithFields(log.Fields{
-		"duration(sec)": time.Since(t).Seconds(),
-		"targetDate":    targetDate,
-	}).Debug("[manager] archiving has been finished")
-	return PutDataIntoDbBucket(common.ConfigBucket, common.LastArchivingDateKey, []byte(targetDate))
+	if err := m.startToDeleteVideosNotToBeArchived(streamIdListNotToArchive, w); err != nil {
+		log.Error("failed to delete videos; " + err.Error())
+	}
+	return nil
 }
 
 func (m *Manager) getStreamIdListToArchive() ([]int64, []int64) {
@@ -122,30 +278,108 @@ func (m *Manager) getStreamIdList() []int64 {
 	return list
 }
 
-func (m *Manager) startToArchiveVideosOnDate(streamIdList []int64, date string) error {
-	var result error
-	for _, streamId := range streamIdList {
-		liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(streamId, 10))
-		dirSize, err := m.archive(streamId, liveDir, date)
-		if err != nil {
-			log.Error(err)
-			result = err
-			continue
+func (m *Manager) runArchiveJob(ctx context.Context, job *common.Job) error {
+	w := &archiveWindow{
+		From: time.Unix(job.From, 0).In(common.Loc),
+		To:   time.Unix(job.To, 0).In(common.Loc),
+	}
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(job.StreamId, 10))
+	dirSize, err := m.archive(ctx, job, liveDir, w)
+	if err != nil {
+		return err
+	}
+	if dirSize < 1 {
+		return nil
+	}
+	return m.writeVideoArchivingHistory(job.StreamId, w.Date(), dirSize)
+}
+
+// addArchiveJobs queues jobs to archive live videos of the stream in the time range on demand
+func (m *Manager) addArchiveJobs(streamId int64, from, to time.Time) ([]*common.Job, error) {
+	if m.getStreamById(streamId) == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+
+	if !from.Before(to) {
+		return nil, common.ErrorInvalidTime
+	}
+
+	// Live videos being written are not archived
+	windows := getWholeArchiveWindows(from, to, time.Now().Add(-archivingGracePeriod), m.getArchiveInterval())
+	if len(windows) < 1 {
+		return nil, common.ErrorInvalidTime
+	}
+	jobs := make([]*common.Job, 0, len(windows))
+	for _, w := range windows {
+		jobs = append(jobs, common.NewJob(common.JobArchive, streamId, w.From, w.To, m.server.config.Archive.MaxAttempts))
+	}
+	if err := m.jobs.add(jobs...); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// getWholeArchiveWindows returns the archive windows which overlap the time range and end until the limit.
+// On-demand jobs archive whole windows like scheduled jobs, so that they never archive a part of a window.
+func getWholeArchiveWindows(from, to, limit time.Time, interval time.Duration) []*archiveWindow {
+	from = from.In(common.Loc)
+	midnight := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, common.Loc)
+	t := midnight.Add(from.Sub(midnight) / interval * interval)
+
+	windows := make([]*archiveWindow, 0)
+	for t.Before(to) {
+		w := newArchiveWindow(t, interval)
+		if w.To.After(limit) {
+			break
 		}
-		if err := m.writeVideoArchivingHistory(streamId, date, dirSize); err != nil {
-			log.Error(err)
-			result = err
+		windows = append(windows, w)
+		t = w.To
+	}
+	return windows
+}
+
+// getArchiveProgress summarizes archive jobs by stream
+func (m *Manager) getArchiveProgress() ([]*common.ArchiveProgress, error) {
+	jobs, err := m.jobs.list(func(job *common.Job) bool {
+		return job.Type == common.JobArchive
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	progressMap := make(map[int64]*common.ArchiveProgress)
+	for _, id := range m.getStreamIdList() {
+		progressMap[id] = &common.ArchiveProgress{StreamId: id}
+	}
+	for _, job := range jobs {
+		p, ok := progressMap[job.StreamId]
+		if !ok {
 			continue
 		}
-
-		//if err := m.deleteLiveDataOnStreamDB(streamId, date); err != nil {
-		//	log.Error(err)
-		//	result = err
-		//	continue
-		//}
+		switch job.Status {
+		case common.JobQueued:
+			p.Queued++
+		case common.JobRunning:
+			p.Running++
+			p.Current = job
+		case common.JobDone:
+			p.Done++
+			if job.To > p.ArchivedUntil {
+				p.ArchivedUntil = job.To
+			}
+		case common.JobFailed:
+			p.Failed++
+		}
 	}
 
-	return result
+	list := make([]*common.ArchiveProgress, 0, len(progressMap))
+	for _, p := range progressMap {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StreamId < list[j].StreamId
+	})
+	return list, nil
 }
 
 func (m *Manager) deleteLiveDataOnStreamDB(streamId int64, date string) error {
@@ -164,29 +398,78 @@ func (m *Manager) deleteLiveDataOnStreamDB(streamId int64, date string) error {
 	})
 }
 
-func (m *Manager) archive(streamId int64, liveDir string, date string) (int64, error) {
-	liveFiles, err := common.ReadVideoFilesOnDateInDir(liveDir, date, common.VideoFileExt)
-	if err != nil {
+// archive merges live videos in the window and appends them to the archived playlist of the day
+func (m *Manager) archive(ctx context.Context, job *common.Job, liveDir string, w *archiveWindow) (int64, error) {
+	streamId := job.StreamId
+	date := w.Date()
+	liveFiles, err := common.ReadVideoFilesInTimeRange(liveDir, w.From, w.To, common.VideoFileExt)
+	if err != nil && !os.IsNotExist(err) {
 		return 0, err
 	}
 
 	if len(liveFiles) < 1 {
 		log.WithFields(log.Fields{
-			"date":     date,
+			"from":     w.From.Format(time.RFC3339),
 			"dir":      liveDir,
 			"streamId": streamId,
 		}).Debug("no video files to archive")
 		return 0, nil
 	}
-	sort.SliceStable(liveFiles, func(i, j int) bool {
-		return liveFiles[i].ModTime().Before(liveFiles[j].ModTime())
-	})
+	recordKey := m.getRecordKey(streamId, date)
+	backend, err := m.getBackend(streamId, date)
+	if err != nil {
+		return 0, err
+	}
+	if job.Stage == archiveStagePublished {
+		// Stopped after the videos had been published
+		common.RemoveLiveFiles(liveDir, liveFiles)
+		return storage.GetSize(backend, recordKey+"/")
+	}
+
+	playlist, err := m.readArchivedPlaylist(backend, recordKey)
+	if err != nil {
+		return 0, err
+	}
+	segmentMap := m.getLiveSegmentMap(streamId, date)
+	if hasSegmentsInWindow(playlist, w) {
+		// Stopped after the playlist had been sent, or a part of the window has been archived by another job.
+		// Only live videos covered by archived segments are deleted, and the others are archived.
+		archived, remaining := splitArchivedLiveFiles(playlist, w, liveFiles, segmentMap)
+		log.WithFields(log.Fields{
+			"streamId":  streamId,
+			"from":      w.From.Format(time.RFC3339),
+			"archived":  len(archived),
+			"remaining": len(remaining),
+		}).Debug("[manager] videos have already been archived")
+		common.RemoveLiveFiles(liveDir, archived)
+		if len(remaining) < 1 {
+			return storage.GetSize(backend, recordKey+"/")
+		}
+		liveFiles = remaining
+	}
+	job.Result = &common.JobResult{InputFiles: len(liveFiles)}
+	for _, f := range liveFiles {
+		job.Result.InputBytes += f.Size()
+	}
+	if err := verifyLiveFilesToArchive(streamId, date, liveDir, liveFiles, segmentMap); err != nil {
+		log.WithFields(log.Fields{
+			"streamId": streamId,
+			"from":     w.From.Format(time.RFC3339),
+		}).Error("[manager] live videos don't match their hashes; live videos are kept")
+		return 0, err
+	}
 
-	recordDir := filepath.ToSlash(filepath.Join(m.server.config.Storage.RecordDir, m.server.config.Storage.Bucket, strconv.FormatInt(streamId, 10), date))
-	if err := hippo.EnsureDir(recordDir); err != nil {
+	// 1. Merge live videos in the staging directory
+	stagingDir := m.getStagingDir(streamId, date)
+	if err := os.RemoveAll(stagingDir); err != nil {
 		return 0, err
 	}
-	listFilePath, err := m.writeLiveFileListToText(liveDir, liveFiles, recordDir)
+	if err := hippo.EnsureDir(stagingDir); err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	listFilePath, err := m.writeLiveFileListToText(liveDir, liveFiles, stagingDir)
 	if err != nil {
 		return 0, err
 	}
@@ -194,10 +477,13 @@ func (m *Manager) archive(streamId int64, liveDir string, date string) (int64, e
 	t := time.Now()
 	log.WithFields(log.Fields{
 		"date": date,
+		"from": w.From.Format(time.RFC3339),
 		"dir":  liveDir,
 	}).Debugf("[manager] found %d video files in stream-%d; merging video files..", len(liveFiles), streamId)
-	err = MergeLiveVideoFiles(listFilePath, filepath.Join(recordDir, common.LiveM3u8FileName), m.server.config.HlsOptions.SegmentTime)
+	chunkPath := filepath.Join(stagingDir, "chunk-"+strconv.FormatInt(w.From.Unix(), 10)+".m3u8")
+	output, err := MergeLiveVideoFiles(ctx, listFilePath, chunkPath, m.server.config.HlsOptions.SegmentTime, getNextMediaNumber(playlist))
 	if err != nil {
+		job.Result.Output = getOutputTail(output)
 		return 0, err
 	}
 	log.WithFields(log.Fields{
@@ -208,35 +494,311 @@ func (m *Manager) archive(streamId int64, liveDir string, date string) (int64, e
 		"duration": time.Since(t).Seconds(),
 	}).Debug("[manager] completed merging video files")
 
-	common.RemoveLiveFiles(liveDir, liveFiles) 
+	// 2. Verify merged videos
+	chunk, err := m.verifyMergedVideos(ctx, job.Result, chunkPath, listFilePath, liveFiles, segmentMap)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"streamId": streamId,
+			"from":     w.From.Format(time.RFC3339),
+		}).Error("[manager] merged videos are invalid; live videos are kept")
+		return 0, err
+	}
+
+	appendChunkToPlaylist(playlist, chunk, getLiveVideoStartTime(liveFiles[0], segmentMap))
+	playlist.Ended = w.isEndOfDay()
+	if playlist.Ended {
+		playlist.Type = streaming.PlaylistVod
+	}
+	if err := writePlaylist(filepath.Join(stagingDir, common.LiveM3u8FileName), playlist); err != nil {
+		return 0, err
+	}
+
+	// 3. Publish; the playlist is sent last so that it never refers to videos which haven't been sent yet
+	videos := make([]string, 0, len(chunk.Segments))
+	for _, seg := range chunk.Segments {
+		videos = append(videos, seg.URI)
+	}
+	published := append([]string{}, videos...)
+	if err := m.writeKeyframeIndex(backend, recordKey, stagingDir, chunk.Segments, nil); err != nil {
+		log.WithFields(log.Fields{
+			"streamId": streamId,
+			"date":     date,
+		}).Error(fmt.Errorf("failed to index keyframes: %w", err))
+	} else {
+		published = append(published, common.KeyframeFileName)
+	}
+	published = append(published, common.LiveM3u8FileName)
+
+	// The manifest is written first so that published videos always have their hashes
+	if err := m.writeArchiveManifest(streamId, date, stagingDir, append(videos, common.LiveM3u8FileName)); err != nil {
+		return 0, err
+	}
+	if err := m.publishArchivedVideos(backend, recordKey, stagingDir, published); err != nil {
+		return 0, err
+	}
+	job.Stage = archiveStagePublished
+	if err := m.jobs.update(job); err != nil {
+		return 0, err
+	}
+
+	// 4. Delete live videos
+	common.RemoveLiveFiles(liveDir, liveFiles)
+
+	return storage.GetSize(backend, recordKey+"/")
+}
+
+// verifyMergedVideos checks the playlist, segments and duration of merged videos
+func (m *Manager) verifyMergedVideos(ctx context.Context, result *common.JobResult, chunkPath, listFilePath string, liveFiles []os.FileInfo, segmentMap map[string]*common.Segment) (*streaming.Playlist, error) {
+	chunk, err := readPlaylist(chunkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse merged playlist: %w", err)
+	}
+	if len(chunk.Segments) < 1 {
+		return nil, errors.New("no merged video files: " + chunkPath)
+	}
+
+	dir := filepath.Dir(chunkPath)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), common.VideoFileExt) {
+			count++
+		}
+	}
+	if count != len(chunk.Segments) {
+		return nil, fmt.Errorf("segment count mismatch: playlist=%d, files=%d", len(chunk.Segments), count)
+	}
+	result.OutputFiles = count
+	for _, seg := range chunk.Segments {
+		f, err := os.Stat(filepath.Join(dir, filepath.Base(seg.URI)))
+		if err != nil {
+			return nil, err
+		}
+		if f.Size() < 1 {
+			return nil, errors.New("empty segment: " + seg.URI)
+		}
+		result.OutputBytes += f.Size()
+	}
+
+	result.SourceDuration, err = getSourceDuration(ctx, listFilePath, liveFiles, segmentMap)
+	if err != nil {
+		return nil, err
+	}
+	result.OutputDuration, err = GetVideoDuration(ctx, chunkPath, false)
+	if err != nil {
+		return nil, err
+	}
+	tolerance := math.Max(archiveDurationTolerance, result.SourceDuration*0.01)
+	if math.Abs(result.SourceDuration-result.OutputDuration) > tolerance {
+		return nil, fmt.Errorf("duration mismatch: source=%.3fs, merged=%.3fs", result.SourceDuration, result.OutputDuration)
+	}
 
-	return streaming.GetDirSize(recordDir)
+	return chunk, nil
+}
 
+// getSourceDuration sums durations of live videos; ffprobe is used if some of them haven't been indexed
+func getSourceDuration(ctx context.Context, listFilePath string, liveFiles []os.FileInfo, segmentMap map[string]*common.Segment) (float64, error) {
+	var duration float64
+	for _, f := range liveFiles {
+		seg, ok := segmentMap[f.Name()]
+		if !ok {
+			return GetVideoDuration(ctx, listFilePath, true)
+		}
+		duration += seg.Duration
+	}
+	return duration, nil
 }
 
-func (m *Manager) startToDeleteVideosNotToBeArchived(streamIdList []int64, targetDate string) error {
+func hasSegmentsInWindow(playlist *streaming.Playlist, w *archiveWindow) bool {
+	for _, seg := range playlist.Segments {
+		if !seg.Time.Before(w.From) && seg.Time.Before(w.To) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArchivedLiveFiles divides live videos in the window into ones covered by archived segments and the others.
+// A live video is archived if its middle is before the end of the archived segments in the window,
+// so that small differences between live and merged durations don't matter.
+func splitArchivedLiveFiles(playlist *streaming.Playlist, w *archiveWindow, liveFiles []os.FileInfo, segmentMap map[string]*common.Segment) ([]os.FileInfo, []os.FileInfo) {
+	var archivedUntil time.Time
+	for _, seg := range playlist.Segments {
+		if seg.Time.Before(w.From) || !seg.Time.Before(w.To) {
+			continue
+		}
+		if end := seg.Time.Add(time.Duration(seg.Duration * float64(time.Second))); end.After(archivedUntil) {
+			archivedUntil = end
+		}
+	}
+
+	archived := make([]os.FileInfo, 0)
+	remaining := make([]os.FileInfo, 0)
+	for _, f := range liveFiles {
+		start := getLiveVideoStartTime(f, segmentMap)
+		middle := start.Add(f.ModTime().Sub(start) / 2)
+		if middle.Before(archivedUntil) {
+			archived = append(archived, f)
+			continue
+		}
+		remaining = append(remaining, f)
+	}
+	return archived, remaining
+}
+
+// getOutputTail returns the last part of ffmpeg output which has error messages
+func getOutputTail(output []byte) string {
+	const max = 4096
+	if len(output) > max {
+		output = output[len(output)-max:]
+	}
+	return string(output)
+}
+
+// publishArchivedVideos sends files to the storage in order; sent files are deleted if it fails
+func (m *Manager) publishArchivedVideos(backend storage.Backend, recordKey, dir string, names []string) error {
+	sent := make([]string, 0, len(names))
+	for _, name := range names {
+		key := storage.Key(recordKey, name)
+		if err := storage.PutFile(backend, key, filepath.Join(dir, name), common.DetectContentType(name)); err != nil {
+			m.rollbackArchivedVideos(backend, sent)
+			return err
+		}
+		// The playlist and the keyframe index replace existing ones
+		if strings.HasSuffix(name, common.VideoFileExt) {
+			sent = append(sent, key)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) rollbackArchivedVideos(backend storage.Backend, keys []string) {
+	for _, key := range keys {
+		if err := backend.Delete(key); err != nil && err != storage.ErrNotFound {
+			log.WithFields(log.Fields{
+				"key": key,
+			}).Error(err)
+		}
+	}
+}
+
+func (m *Manager) readArchivedPlaylist(backend storage.Backend, recordKey string) (*streaming.Playlist, error) {
+	object, err := backend.Get(storage.Key(recordKey, common.LiveM3u8FileName))
+	if err == storage.ErrNotFound {
+		return streaming.NewPlaylist(streaming.PlaylistEvent), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return streaming.ParsePlaylist(object)
+}
+
+// getRecordKey returns the storage key prefix of archived videos of the date
+func (m *Manager) getRecordKey(streamId int64, date string) string {
+	return storage.Key(strconv.FormatInt(streamId, 10), date)
+}
+
+func (m *Manager) getStagingDir(streamId int64, date string) string {
+	return filepath.Join(m.server.config.Storage.LiveDir, ".staging", strconv.FormatInt(streamId, 10), date)
+}
+
+func (m *Manager) getLiveSegmentMap(streamId int64, date string) map[string]*common.Segment {
+	segmentMap := make(map[string]*common.Segment)
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return segmentMap
+	}
+	segments, err := stream.GetSegments(date)
+	if err != nil {
+		log.Error(err)
+		return segmentMap
+	}
+	for _, seg := range segments {
+		segmentMap[seg.URI] = seg
+	}
+	return segmentMap
+}
+
+// getLiveVideoStartTime returns the time when the first live video of the chunk began
+func getLiveVideoStartTime(f os.FileInfo, segmentMap map[string]*common.Segment) time.Time {
+	duration := 1.0 // seconds; hls_time of live streaming
+	if seg, ok := segmentMap[f.Name()]; ok {
+		duration = seg.Duration
+	}
+	return f.ModTime().Add(-time.Duration(duration * float64(time.Second))).In(common.Loc)
+}
+
+func readPlaylist(path string) (*streaming.Playlist, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return streaming.NewPlaylist(streaming.PlaylistEvent), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return streaming.ParsePlaylist(file)
+}
+
+// writePlaylist replaces the playlist atomically
+func writePlaylist(path string, playlist *streaming.Playlist) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, playlist.Encode(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func getNextMediaNumber(playlist *streaming.Playlist) int {
+	next := 0
+	for _, seg := range playlist.Segments {
+		if seq, err := streaming.GetVideoFileSeq(seg.URI); err == nil && seq >= next {
+			next = seq + 1
+		}
+	}
+	return next
+}
+
+func appendChunkToPlaylist(playlist, chunk *streaming.Playlist, start time.Time) {
+	t := start
+	for i, seg := range chunk.Segments {
+		seg.URI = filepath.Base(seg.URI)
+		seg.Time = t
+		seg.Discontinuity = i == 0 && len(playlist.Segments) > 0
+		playlist.Segments = append(playlist.Segments, seg)
+		t = t.Add(time.Duration(seg.Duration * float64(time.Second)))
+	}
+}
+
+func (m *Manager) startToDeleteVideosNotToBeArchived(streamIdList []int64, w *archiveWindow) error {
 	if len(streamIdList) < 1 {
 		return nil
 	}
 	for _, streamId := range streamIdList {
 		liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(streamId, 10))
-		filesToDelete, err := common.ReadVideoFilesOnDateInDir(liveDir, targetDate, common.VideoFileExt)
+		filesToDelete, err := common.ReadVideoFilesInTimeRange(liveDir, w.From, w.To, common.VideoFileExt)
 		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
 			log.WithFields(log.Fields{
-				"streamId":   streamId,
-				"targetDate": targetDate,
-				"dir":        liveDir,
-			})
-			log.Error("failed to remove unnecessary video files")
+				"streamId": streamId,
+				"from":     w.From.Format(time.RFC3339),
+				"dir":      liveDir,
+			}).Error("failed to remove unnecessary video files")
 			continue
 		}
 		deleted := common.RemoveLiveFiles(liveDir, filesToDelete)
 		log.WithFields(log.Fields{
-			"streamId":   streamId,
-			"targetDate": targetDate,
-			"dir":        liveDir,
-			"deleted":    deleted,
-		}).Debug("[manater] removed unnecessary video files")
+			"streamId": streamId,
+			"from":     w.From.Format(time.RFC3339),
+			"dir":      liveDir,
+			"deleted":  deleted,
+		}).Debug("[manager] removed unnecessary video files")
 	}
 	return nil
 }
@@ -262,15 +824,21 @@ func (m *Manager) writeLiveFileListToText(liveDir string, files []os.FileInfo, r
 	return f.Name(), err
 }
 
+// writeVideoArchivingHistory saves the size of the archived day; the tier of the day is kept
 func (m *Manager) writeVideoArchivingHistory(streamId int64, date string, dirSize int64) error {
 	bucketName := []byte(common.VideoBucketPrefix + strconv.FormatInt(streamId, 10))
-	size := common.Int64ToBytes(dirSize)
 	return db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(bucketName)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(date), size)
+		day := common.ParseDayArchive(bucket.Get([]byte(date)))
+		day.Size = dirSize
+		data, err := json.Marshal(day)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(date), data)
 	})
 }
 
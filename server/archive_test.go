@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type liveFileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (f *liveFileInfo) Name() string       { return f.name }
+func (f *liveFileInfo) Size() int64        { return 1024 }
+func (f *liveFileInfo) Mode() os.FileMode  { return 0644 }
+func (f *liveFileInfo) ModTime() time.Time { return f.modTime }
+func (f *liveFileInfo) IsDir() bool        { return false }
+func (f *liveFileInfo) Sys() interface{}   { return nil }
+
+// newLiveFiles returns live videos of 2 seconds from "from" to "to"
+func newLiveFiles(from, to time.Time) ([]os.FileInfo, map[string]*common.Segment) {
+	files := make([]os.FileInfo, 0)
+	segmentMap := make(map[string]*common.Segment)
+	for t, i := from, 0; t.Before(to); t, i = t.Add(2*time.Second), i+1 {
+		name := fmt.Sprintf("live%d.ts", i)
+		files = append(files, &liveFileInfo{name: name, modTime: t.Add(2 * time.Second)})
+		segmentMap[name] = &common.Segment{Duration: 2}
+	}
+	return files, segmentMap
+}
+
+// newArchivedPlaylist returns the playlist of merged videos of 10 minutes; durations slightly differ from live videos
+func newArchivedPlaylist(from, to time.Time) *streaming.Playlist {
+	playlist := streaming.NewPlaylist(streaming.PlaylistEvent)
+	for t := from; t.Before(to); t = t.Add(10 * time.Minute) {
+		playlist.Segments = append(playlist.Segments, &streaming.PlaylistSegment{
+			URI:      "media.ts",
+			Duration: 599.96,
+			Time:     t,
+		})
+	}
+	return playlist
+}
+
+func TestGetWholeArchiveWindows(t *testing.T) {
+	common.Loc = time.UTC
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, common.Loc)
+	limit := day.Add(12 * time.Hour)
+
+	// An on-demand job of 10:00-10:20 archives the whole window of 10:00-11:00
+	windows := getWholeArchiveWindows(day.Add(10*time.Hour), day.Add(10*time.Hour+20*time.Minute), limit, time.Hour)
+	if len(windows) != 1 || !windows[0].From.Equal(day.Add(10*time.Hour)) || !windows[0].To.Equal(day.Add(11*time.Hour)) {
+		t.Fatalf("unexpected windows: %v", windows)
+	}
+
+	// Windows are aligned even if the range starts in the middle of a window
+	windows = getWholeArchiveWindows(day.Add(10*time.Hour+30*time.Minute), day.Add(11*time.Hour+10*time.Minute), limit, time.Hour)
+	if len(windows) != 2 || !windows[0].From.Equal(day.Add(10*time.Hour)) || !windows[1].To.Equal(day.Add(12*time.Hour)) {
+		t.Fatalf("unexpected windows: %v", windows)
+	}
+
+	// Windows which haven't ended are not archived
+	windows = getWholeArchiveWindows(day.Add(11*time.Hour+30*time.Minute), day.Add(12*time.Hour+30*time.Minute), limit, time.Hour)
+	if len(windows) != 1 || !windows[0].To.Equal(limit) {
+		t.Fatalf("unexpected windows: %v", windows)
+	}
+	if windows = getWholeArchiveWindows(limit, limit.Add(time.Hour), limit, time.Hour); len(windows) != 0 {
+		t.Fatalf("unexpected windows: %v", windows)
+	}
+}
+
+// An on-demand job archived 10:00-10:20, then the scheduled job of 10:00-11:00 runs
+func TestSplitArchivedLiveFiles(t *testing.T) {
+	common.Loc = time.UTC
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, common.Loc)
+	w := &archiveWindow{From: day.Add(10 * time.Hour), To: day.Add(11 * time.Hour)}
+	archivedUntil := day.Add(10*time.Hour + 20*time.Minute)
+
+	liveFiles, segmentMap := newLiveFiles(w.From, w.To)
+
+	playlist := newArchivedPlaylist(w.From, archivedUntil)
+	if !hasSegmentsInWindow(playlist, w) {
+		t.Fatal("segments of the on-demand job are not found")
+	}
+	archived, remaining := splitArchivedLiveFiles(playlist, w, liveFiles, segmentMap)
+	if len(archived)+len(remaining) != len(liveFiles) {
+		t.Fatalf("files are lost; archived=%d, remaining=%d, total=%d", len(archived), len(remaining), len(liveFiles))
+	}
+	for _, f := range archived {
+		if f.ModTime().After(archivedUntil) {
+			t.Errorf("%s (%s) has not been archived but is deleted", f.Name(), f.ModTime().Format(time.RFC3339))
+		}
+	}
+	for _, f := range remaining {
+		if !f.ModTime().After(archivedUntil) {
+			t.Errorf("%s (%s) has been archived but is archived again", f.Name(), f.ModTime().Format(time.RFC3339))
+		}
+	}
+	if len(remaining) != 40*60/2 {
+		t.Fatalf("expected %d remaining files, got %d", 40*60/2, len(remaining))
+	}
+
+	// Nothing is left once the whole window has been archived
+	archived, remaining = splitArchivedLiveFiles(newArchivedPlaylist(w.From, w.To), w, liveFiles, segmentMap)
+	if len(archived) != len(liveFiles) || len(remaining) != 0 {
+		t.Fatalf("expected all files archived; archived=%d, remaining=%d", len(archived), len(remaining))
+	}
+}
+
+func TestVerifyLiveFilesToArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-stream-live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	segmentMap := make(map[string]*common.Segment)
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("live%d.ts", i)
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := streaming.GetHashFromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segmentMap[name] = &common.Segment{Size: int64(len(name)), Hash: hex.EncodeToString(hash)}
+	}
+	// Not indexed yet
+	if err := ioutil.WriteFile(filepath.Join(dir, "live3.ts"), []byte("live3.ts"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readLiveFiles := func() []os.FileInfo {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	if err := verifyLiveFilesToArchive(1, "20200301", dir, readLiveFiles(), segmentMap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Altered with the same size
+	if err := ioutil.WriteFile(filepath.Join(dir, "live1.ts"), []byte("LIVE1.TS"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyLiveFilesToArchive(1, "20200301", dir, readLiveFiles(), segmentMap); !errors.Is(err, common.ErrorAlteredVideos) {
+		t.Fatalf("expected ErrorAlteredVideos, got %v", err)
+	}
+}
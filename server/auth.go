@@ -0,0 +1,505 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "session"
+	initialAdmin      = "admin"
+	minPasswordLength = 8
+)
+
+type contextKey int
+
+const principalContextKey contextKey = iota
+
+// permission of a route; users need the role or above, and API tokens need the scope.
+// Routes without a scope are only for users. The action is checked on the stream of the route by the ACL of the user.
+type permission struct {
+	role   string
+	scope  string
+	action string // empty: any permission on the stream
+}
+
+var (
+	permPage          = permission{common.RoleViewer, "", ""} // pages, assets and the current user
+	permOperatorPage  = permission{common.RoleOperator, "", ""}
+	permAdmin         = permission{common.RoleAdmin, "", ""}
+	permStreamsRead   = permission{common.RoleViewer, common.ScopeStreamsRead, ""}
+	permStreamsWrite  = permission{common.RoleAdmin, common.ScopeStreamsWrite, ""}
+	permStreamControl = permission{common.RoleOperator, common.ScopeStreamsWrite, common.AclControl}
+	permLive          = permission{common.RoleViewer, common.ScopeMediaRead, common.AclLive}
+	permMediaRead     = permission{common.RoleViewer, common.ScopeMediaRead, common.AclRecordings}
+	permVerify        = permission{common.RoleOperator, common.ScopeMediaRead, common.AclRecordings}
+	permAnnotate      = permission{common.RoleOperator, common.ScopeAnnotationsWrite, common.AclRecordings}
+	permExport        = permission{common.RoleOperator, common.ScopeExport, common.AclExport}
+	permArchive       = permission{common.RoleOperator, common.ScopeArchive, common.AclControl}
+	permSystemRead    = permission{common.RoleAdmin, common.ScopeSystemRead, ""}
+)
+
+// principal is a logged in user or an API token
+type principal struct {
+	user    *common.User
+	token   *common.ApiToken
+	groupOf func(streamId int64) string
+}
+
+// name identifies the principal in records like authors of annotations
+func (p *principal) name() string {
+	if p.token != nil {
+		return "token:" + p.token.Name
+	}
+	return p.user.Username
+}
+
+func (p *principal) allowed(perm permission) bool {
+	if p.token != nil {
+		return len(perm.scope) > 0 && p.token.HasScope(perm.scope)
+	}
+	return common.RoleLevel(p.user.Role) >= common.RoleLevel(perm.role)
+}
+
+// canAccessStream checks the ACL of users and stream restrictions of API tokens
+func (p *principal) canAccessStream(id int64, action string) bool {
+	if p.user != nil {
+		return p.user.Allows(id, p.groupOf(id), action)
+	}
+	if len(p.token.Streams) < 1 {
+		return true
+	}
+	for _, s := range p.token.Streams {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._@-]{1,64}$`)
+
+	// Compared with passwords of unknown users so that they take as long as the others
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+)
+
+// initUsers creates the initial admin if there are no users
+func (s *Server) initUsers() error {
+	var empty bool
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(common.UserBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(common.SessionBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(common.TokenBucket); err != nil {
+			return err
+		}
+		k, _ := b.Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	if err != nil || !empty {
+		return err
+	}
+
+	password := s.config.Auth.AdminPassword
+	generated := len(password) < 1
+	if generated {
+		if password, err = newToken(12); err != nil {
+			return err
+		}
+	}
+	if err := addUser(&common.User{Username: initialAdmin, Role: common.RoleAdmin}, password); err != nil {
+		return err
+	}
+
+	fields := log.Fields{"username": initialAdmin}
+	if generated {
+		fields["password"] = password
+	}
+	log.WithFields(fields).Warn("[server] initial admin has been created; change the password")
+	return nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < minPasswordLength {
+		return nil, common.ErrorInvalidPassword
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func getUser(username string) (*common.User, error) {
+	var user *common.User
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(common.UserBucket).Get([]byte(username))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, common.ErrorUserNotFound
+	}
+	return user, nil
+}
+
+// getUsers returns users without password hashes
+func getUsers() ([]*common.User, error) {
+	users := make([]*common.User, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(common.UserBucket).ForEach(func(k, v []byte) error {
+			var user common.User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			user.Password = nil
+			users = append(users, &user)
+			return nil
+		})
+	})
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, err
+}
+
+func putUser(b *bolt.Bucket, user *common.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(user.Username), data)
+}
+
+func addUser(user *common.User, password string) error {
+	if !usernameRegexp.MatchString(user.Username) || common.RoleLevel(user.Role) < 1 {
+		return common.ErrorInvalidUser
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	user.Created = time.Now().Unix()
+	user.LastLogin = 0
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.UserBucket)
+		if b.Get([]byte(user.Username)) != nil {
+			return common.ErrorDuplicatedUser
+		}
+		return putUser(b, user)
+	})
+}
+
+// updateUser changes the role and the password of the user if they're not empty.
+// Sessions of the user are deleted when the password is changed.
+func updateUser(username, role, password string) (*common.User, error) {
+	if len(role) > 0 && common.RoleLevel(role) < 1 {
+		return nil, common.ErrorInvalidUser
+	}
+	var hash []byte
+	if len(password) > 0 {
+		h, err := hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		hash = h
+	}
+
+	var user *common.User
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.UserBucket)
+		data := b.Get([]byte(username))
+		if data == nil {
+			return common.ErrorUserNotFound
+		}
+		if err := json.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		if len(role) > 0 && role != user.Role {
+			if user.Role == common.RoleAdmin && countAdmins(b) < 2 {
+				return common.ErrorLastAdmin
+			}
+			user.Role = role
+		}
+		if hash != nil {
+			user.Password = hash
+			if err := deleteUserSessions(tx, username); err != nil {
+				return err
+			}
+		}
+		return putUser(b, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Password = nil
+	return user, nil
+}
+
+func deleteUser(username string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.UserBucket)
+		data := b.Get([]byte(username))
+		if data == nil {
+			return common.ErrorUserNotFound
+		}
+		var user common.User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		if user.Role == common.RoleAdmin && countAdmins(b) < 2 {
+			return common.ErrorLastAdmin
+		}
+		if err := deleteUserSessions(tx, username); err != nil {
+			return err
+		}
+		return b.Delete([]byte(username))
+	})
+}
+
+func countAdmins(b *bolt.Bucket) int {
+	count := 0
+	b.ForEach(func(k, v []byte) error {
+		var user common.User
+		if json.Unmarshal(v, &user) == nil && user.Role == common.RoleAdmin {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// authenticateUser checks the password and records the login time
+func authenticateUser(username, password string) (*common.User, error) {
+	user, err := getUser(username)
+	if err == common.ErrorUserNotFound {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, common.ErrorLoginFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(password)); err != nil {
+		return nil, common.ErrorLoginFailed
+	}
+
+	user.LastLogin = time.Now().Unix()
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putUser(tx.Bucket(common.UserBucket), user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.Password = nil
+	return user, nil
+}
+
+// newToken returns a random hex string of the size in bytes
+func newToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sessionKey is the key of the session in the database; tokens themselves are not stored
+func sessionKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// createSession issues a session token and deletes expired sessions
+func createSession(username string, timeout time.Duration) (string, *common.Session, error) {
+	token, err := newToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	session := &common.Session{
+		Username: username,
+		Created:  now.Unix(),
+		Expires:  now.Add(timeout).Unix(),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.SessionBucket)
+		expired := make([][]byte, 0)
+		b.ForEach(func(k, v []byte) error {
+			var s common.Session
+			if json.Unmarshal(v, &s) != nil || s.Expires < now.Unix() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put(sessionKey(token), data)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// getSessionUser returns the user of the valid session
+func getSessionUser(token string) (*common.User, error) {
+	var session *common.Session
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(common.SessionBucket).Get(sessionKey(token))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.Expires < time.Now().Unix() {
+		return nil, common.ErrorUnauthorized
+	}
+
+	user, err := getUser(session.Username)
+	if err == common.ErrorUserNotFound {
+		return nil, common.ErrorUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Password = nil
+	return user, nil
+}
+
+func deleteSession(token string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(common.SessionBucket).Delete(sessionKey(token))
+	})
+}
+
+func deleteUserSessions(tx *bolt.Tx, username string) error {
+	b := tx.Bucket(common.SessionBucket)
+	keys := make([][]byte, 0)
+	err := b.ForEach(func(k, v []byte) error {
+		var s common.Session
+		if json.Unmarshal(v, &s) == nil && s.Username == username {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authenticate returns the principal of the request from the bearer token or the session cookie
+func (c *Controller) authenticate(r *http.Request) (*principal, error) {
+	if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, common.ErrorUnauthorized
+		}
+		token, err := getValidToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+		if err != nil {
+			return nil, err
+		}
+		return &principal{token: token, groupOf: c.manager.getStreamGroup}, nil
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || len(cookie.Value) < 1 {
+		return nil, common.ErrorUnauthorized
+	}
+	user, err := getSessionUser(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &principal{user: user, groupOf: c.manager.getStreamGroup}, nil
+}
+
+// authorize allows principals with the permission to call the handler, and checks the stream of the route.
+// Pages redirect anonymous users to the login page; the others respond with 401.
+// Handlers of routes across streams filter them with canAccessStream().
+func (c *Controller) authorize(perm permission, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := c.authenticate(r)
+		if err != nil {
+			if err != common.ErrorUnauthorized {
+				Response(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") && len(r.Header.Get("Authorization")) < 1 {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rtsp-stream"`)
+			Response(w, r, err, http.StatusUnauthorized)
+			return
+		}
+		if !p.allowed(perm) {
+			Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+			return
+		}
+		if str, ok := mux.Vars(r)["id"]; ok {
+			id, _ := strconv.ParseInt(str, 10, 64)
+			if !p.canAccessStream(id, perm.action) {
+				Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+				return
+			}
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
+	}
+}
+
+// requestPrincipal returns the principal authorized for the request
+func requestPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalContextKey).(*principal)
+	return p
+}
+
+// canAccessStream checks if the principal of the request can do the action on the stream
+func canAccessStream(r *http.Request, id int64, action string) bool {
+	p := requestPrincipal(r)
+	return p != nil && p.canAccessStream(id, action)
+}
+
+// safeRedirect allows redirecting only to paths of this server
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/live/"
+	}
+	return next
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/devplayg/rtsp-stream/common"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const bundleManifestVersion = 1
+
+// getSigningKey loads the ed25519 key of the server; the key is generated on first use
+func (m *Manager) getSigningKey() (ed25519.PrivateKey, error) {
+	m.keyOnce.Do(func() {
+		path := filepath.Join(m.server.dbDir, "export.key")
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+			if err != nil || len(seed) != ed25519.SeedSize {
+				m.keyErr = errors.New("invalid signing key: " + path)
+				return
+			}
+			m.signingKey = ed25519.NewKeyFromSeed(seed)
+			return
+		}
+		if !os.IsNotExist(err) {
+			m.keyErr = err
+			return
+		}
+
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			m.keyErr = err
+			return
+		}
+		if err := ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key.Seed())), 0600); err != nil {
+			m.keyErr = err
+			return
+		}
+		m.signingKey = key
+		log.WithFields(log.Fields{
+			"file": path,
+		}).Info("[manager] signing key has been generated")
+	})
+	return m.signingKey, m.keyErr
+}
+
+func (m *Manager) getPublicKey() (string, error) {
+	key, err := m.getSigningKey()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), nil
+}
+
+// writeEvidenceBundle packages the clip, original segments and the signed manifest into a zip file
+func (m *Manager) writeEvidenceBundle(job *common.Job, clipPath string, sources []*exportSource, path string) error {
+	key, err := m.getSigningKey()
+	if err != nil {
+		return err
+	}
+	stream := m.getStreamById(job.StreamId)
+	if stream == nil {
+		return common.ErrorStreamNotFound
+	}
+
+	manifest := &common.BundleManifest{
+		Version:    bundleManifestVersion,
+		StreamId:   stream.Id,
+		CameraName: stream.Name,
+		UriHash:    stream.UriHash,
+		From:       time.Unix(job.From, 0).In(common.Loc).Format(time.RFC3339),
+		To:         time.Unix(job.To, 0).In(common.Loc).Format(time.RFC3339),
+		Timezone:   common.Loc.String(),
+		Created:    time.Now().In(common.Loc).Format(time.RFC3339),
+		Segments:   make([]*common.BundleFile, 0, len(sources)),
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	w := zip.NewWriter(f)
+	manifest.Clip, err = addFileToZip(w, common.BundleClipFileName, clipPath)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, src := range sources {
+		file, err := addFileToZip(w, common.BundleSegmentDir+filepath.Base(src.path), src.path)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		file.Start = src.start.In(common.Loc).Format(time.RFC3339Nano)
+		file.Duration = src.duration
+		manifest.Segments = append(manifest.Segments, file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	signature := ed25519.Sign(key, data)
+	publicKey := key.Public().(ed25519.PublicKey)
+	files := map[string][]byte{
+		common.BundleManifestFileName:  data,
+		common.BundleSignatureFileName: []byte(base64.StdEncoding.EncodeToString(signature)),
+		common.BundlePublicKeyFileName: []byte(base64.StdEncoding.EncodeToString(publicKey)),
+	}
+	for _, name := range []string{common.BundleManifestFileName, common.BundleSignatureFileName, common.BundlePublicKeyFileName} {
+		fw, err := w.Create(name)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := fw.Write(files[name]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// addFileToZip stores the file without compression and returns its size and SHA-256 hash
+func addFileToZip(w *zip.Writer, name, path string) (*common.BundleFile, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fw, hash), in)
+	if err != nil {
+		return nil, err
+	}
+	return &common.BundleFile{
+		Name:   name,
+		Size:   size,
+		Sha256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
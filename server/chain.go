@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+func getChainAnchorBucketName(streamId int64) []byte {
+	return []byte(fmt.Sprintf("%s%d", common.ChainBucketPrefix, streamId))
+}
+
+// anchorChainHeads saves heads of the closed days in the main database
+func (m *Manager) anchorChainHeads() error {
+	today := time.Now().In(common.Loc).Format(common.DateFormat)
+	for _, stream := range m.getStreams() {
+		if stream.DB == nil {
+			continue
+		}
+		dates, err := stream.GetChainDates()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, date := range dates {
+			if date >= today {
+				continue
+			}
+			anchor, err := m.getChainAnchor(stream.Id, date)
+			if err != nil {
+				return err
+			}
+			if anchor != nil {
+				continue
+			}
+			head, err := stream.GetChainHead(date)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if err := m.putChainAnchor(stream.Id, head); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"streamId": stream.Id,
+				"date":     date,
+				"count":    head.Count,
+				"head":     head.Hash,
+			}).Debug("[manager] chain head has been anchored")
+		}
+	}
+	return nil
+}
+
+func (m *Manager) getChainAnchor(streamId int64, date string) (*common.ChainHead, error) {
+	var anchor *common.ChainHead
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(getChainAnchorBucketName(streamId))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(date))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &anchor)
+	})
+	return anchor, err
+}
+
+func (m *Manager) putChainAnchor(streamId int64, head *common.ChainHead) error {
+	head.Anchored = time.Now().Unix()
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(getChainAnchorBucketName(streamId))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(head.Date), data)
+	})
+}
+
+// getChainStatus verifies the chain of the day against the anchored head
+func (m *Manager) getChainStatus(streamId int64, date string) (*common.ChainStatus, error) {
+	if _, err := time.ParseInLocation(common.DateFormat, date, common.Loc); err != nil {
+		return nil, common.ErrorInvalidDate
+	}
+	stream := m.getStreamById(streamId)
+	if stream == nil || stream.DB == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+
+	anchor, err := m.getChainAnchor(streamId, date)
+	if err != nil {
+		return nil, err
+	}
+	return stream.VerifyChain(date, anchor)
+}
+
+// deleteChain deletes the chain and its anchor of the expired day
+func (m *Manager) deleteChain(stream *streaming.Stream, date string) error {
+	if stream.DB != nil {
+		if err := stream.DeleteChain(date); err != nil {
+			return err
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(getChainAnchorBucketName(stream.Id))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(date))
+	})
+}
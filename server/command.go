@@ -1,13 +1,16 @@
 package server
 
 import (
+	"context"
 	"github.com/devplayg/rtsp-stream/common"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
-func MergeLiveVideoFiles(listFilePath, metaFilePath string, segmentTime int) error {
+// MergeLiveVideoFiles returns the output of ffmpeg as well
+func MergeLiveVideoFiles(ctx context.Context, listFilePath, metaFilePath string, segmentTime, startNumber int) ([]byte, error) {
 	//inputFile, _ := filepath.Abs(listFilePath)
 	//outputFile := filepath.Base(metaFilePath)
 
@@ -24,7 +27,8 @@ func MergeLiveVideoFiles(listFilePath, metaFilePath string, segmentTime int) err
 	//}
 	//defer os.Chdir(originDir)
 
-	cmd := exec.Command(
+	cmd := exec.CommandContext(
+		ctx,
 		"ffmpeg",
 		"-y",
 		"-f",
@@ -43,6 +47,8 @@ func MergeLiveVideoFiles(listFilePath, metaFilePath string, segmentTime int) err
 		"+cache",
 		"-segment_time",
 		strconv.Itoa(segmentTime),
+		"-segment_start_number",
+		strconv.Itoa(startNumber),
 		filepath.Join(filepath.Dir(metaFilePath), common.VideoFilePrefix+"%d.ts"),
 	)
 	//output, err := cmd.CombinedOutput()
@@ -53,10 +59,88 @@ func MergeLiveVideoFiles(listFilePath, metaFilePath string, segmentTime int) err
 	//log.WithFields(log.Fields{
 	//	"name": "MergeLiveVideoFiles",
 	//}).Debug(cmd.Args)
-	err := cmd.Run()
+	output, err := cmd.CombinedOutput()
 	//if err != nil {
 	//	log.Error(cmd.Args)
 	//}
 
-	return err
+	return output, err
+}
+
+// GetVideoDuration returns the duration in seconds of the video, playlist or concat list(concat)
+func GetVideoDuration(ctx context.Context, path string, concat bool) (float64, error) {
+	args := []string{"-v", "error"}
+	if concat {
+		args = append(args, "-f", "concat", "-safe", "0")
+	}
+	args = append(args, "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", "-i", path)
+	output, err := exec.CommandContext(ctx, "ffprobe", args...).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+}
+
+// ExportVideo cuts the concatenated videos into a MP4 file; videos are re-encoded if transcode is true.
+// The input is seeked so that copied videos start at the keyframe at or before the offset instead of broken frames.
+func ExportVideo(ctx context.Context, listFilePath, outputPath string, offset, duration float64, transcode bool) ([]byte, error) {
+	args := []string{
+		"-y",
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64),
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFilePath,
+		"-t", strconv.FormatFloat(duration, 'f', 3, 64),
+	}
+	if transcode {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac")
+	} else {
+		args = append(args, "-c", "copy", "-bsf:a", "aac_adtstoasc")
+	}
+	args = append(args, "-movflags", "+faststart", outputPath)
+
+	return exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+}
+
+// TranscodeVideo re-encodes the segment by the policy; timestamps are kept so that segments stay continuous
+func TranscodeVideo(ctx context.Context, inputPath, outputPath string, policy *common.TranscodePolicy) ([]byte, error) {
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-copyts",
+		"-map", "0",
+	}
+	if policy.Codec == common.CodecH264 {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast")
+	} else {
+		args = append(args, "-c:v", "libx265", "-preset", "fast")
+	}
+	if policy.Height > 0 {
+		args = append(args, "-vf", "scale=-2:"+strconv.Itoa(policy.Height))
+	}
+	if policy.BitrateKbps > 0 {
+		args = append(args, "-b:v", strconv.Itoa(policy.BitrateKbps)+"k")
+	} else {
+		args = append(args, "-crf", "28")
+	}
+	args = append(args, "-c:a", "copy", "-f", "mpegts", outputPath)
+
+	return exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+}
+
+// MakeFillerVideo makes a black video which pads gaps of synchronized playlists
+func MakeFillerVideo(ctx context.Context, outputPath string, seconds int) ([]byte, error) {
+	args := []string{
+		"-y",
+		"-f", "lavfi",
+		"-i", "color=c=black:s=640x360:r=10",
+		"-t", strconv.Itoa(seconds),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-pix_fmt", "yuv420p",
+		"-f", "mpegts",
+		outputPath,
+	}
+
+	return exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
 }
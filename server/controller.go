@@ -1,20 +1,20 @@
 package server
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
 	"github.com/devplayg/rtsp-stream/streaming"
 	"github.com/devplayg/rtsp-stream/ui"
 	"github.com/gorilla/mux"
-	"github.com/minio/minio-go"
 	log "github.com/sirupsen/logrus"
 	"html/template"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -86,7 +86,10 @@ func (c *Controller) DisplayLive(w http.ResponseWriter, r *http.Request) {
 	if tmpl, err = tmpl.Parse(ui.LivePage()); err != nil {
 		Response(w, r, err, http.StatusInternalServerError)
 	}
-	if err := tmpl.Execute(w, c.manager.getLiveData()); err != nil {
+	data := c.manager.getLiveData(func(id int64) bool {
+		return canAccessStream(r, id, common.AclLive)
+	})
+	if err := tmpl.Execute(w, data); err != nil {
 		Response(w, r, err, http.StatusInternalServerError)
 	}
 }
@@ -100,6 +103,17 @@ func (c *Controller) GetVideoRecords(w http.ResponseWriter, r *http.Request) {
 		Response(w, r, err, http.StatusBadRequest)
 		return
 	}
+	for _, day := range videos {
+		for name := range day {
+			if !strings.HasPrefix(name, common.VideoBucketPrefix) {
+				continue
+			}
+			id, _ := strconv.ParseInt(strings.TrimPrefix(name, common.VideoBucketPrefix), 10, 64)
+			if !canAccessStream(r, id, common.AclRecordings) {
+				delete(day, name)
+			}
+		}
+	}
 
 	data, err := json.MarshalIndent(videos, "", "  ")
 	if err != nil {
@@ -128,7 +142,12 @@ func (c *Controller) AddStream(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) GetStreams(w http.ResponseWriter, r *http.Request) {
-	streams := c.manager.getSimpleStreams()
+	streams := make([]*streaming.SimpleStream, 0)
+	for _, s := range c.manager.getSimpleStreams() {
+		if canAccessStream(r, s.Id, "") {
+			streams = append(streams, s)
+		}
+	}
 	data, err := json.MarshalIndent(streams, "", "  ")
 	if err != nil {
 		Response(w, r, err, http.StatusInternalServerError)
@@ -212,6 +231,7 @@ func (c *Controller) GetStreamById(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// GetTodayM3u8 returns the playlist of today; videos archived by windows are followed by live videos not archived yet
 func (c *Controller) GetTodayM3u8(w http.ResponseWriter, r *http.Request) {
 	streamId, err := streaming.ParseAndGetStreamId(r)
 	if err != nil {
@@ -219,19 +239,26 @@ func (c *Controller) GetTodayM3u8(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tags, err := c.manager.getM3u8(streamId, time.Now().In(common.Loc).Format(common.DateFormat))
+	now := time.Now().In(common.Loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, common.Loc)
+	playlist, err := c.manager.getRangePlaylist(streamId, midnight, now)
 	if err != nil {
+		if err == common.ErrorColdUnavailable {
+			Response(w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		if err == common.ErrorStreamNotFound {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
 		Response(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	//path := filepath.Join(c.server.config.Storage.LiveDir, strconv.FormatInt(streamId, 10), "today.m3u8")
-	//http.ServeFile(w, r, path)
-
+	data := playlist.Encode()
 	w.Header().Set("Content-Type", common.ContentTypeM3u8)
-	w.Header().Set("Content-Length", strconv.Itoa(len(tags)))
-	//w.Header().Set("Accept-Range", "bytes")
-	w.Write([]byte(tags))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
 }
 
 func (c *Controller) GetLiveM3u8(w http.ResponseWriter, r *http.Request) {
@@ -379,61 +406,6 @@ func (c *Controller) GetTodayVideo(w http.ResponseWriter, r *http.Request) {
 //
 //}
 
-func (c *Controller) GetDailyVideoOld(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	objectName := filepath.ToSlash(filepath.Join(vars["id"], vars["date"], vars["media"]+".ts"))
-	object, err := common.MinioClient.GetObject(common.VideoRecordBucket, objectName, minio.GetObjectOptions{})
-	if err != nil {
-		Response(w, r, err, http.StatusInternalServerError)
-		return
-	}
-
-	//reader := bufio.NewReader(object)
-	//s, _ := object.Stat()
-	//s.Size
-	//info, _ := object.Stat()
-
-	w.Header().Set("Accept-Range", "bytes")
-	w.Header().Set("Content-Type", common.ContentTypeTs)
-
-	//if _, err = io.Copy(w, object); err != nil{
-	//    Response(w, r,err, http.StatusInternalServerError)
-	//    return
-	//}
-
-	buf := new(bytes.Buffer)
-	n, err := buf.ReadFrom(object)
-	if err != nil {
-		Response(w, r, err, http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
-	w.WriteHeader(http.StatusOK)
-	w.Write(buf.Bytes())
-
-	//Accept-Ranges: bytes
-	//Content-Length: 1099988
-	//Content-Type: video/vnd.dlna.mpeg-tts
-	//Date: Tue, 26 Nov 2019 10:50:15 GMT
-	//Last-Modified: Tue, 26 Nov 2019 10:34:51 GMT
-
-	//w.WriteHeader(http.StatusOK)
-	//b := bytes.NewBuffer(object)
-	//bufre
-
-	//fmt.Fprintf()
-	//localFile, err := os.Create("/tmp/local-file.jpg")
-	//if err != nil {
-	//    fmt.Println(err)
-	//    return
-	//}
-	//if _, err = io.Copy(localFile, object); err != nil {
-	//   fmt.Println(err)
-	//   return
-	//}
-
-}
-
 //func (c *Controller) RedirectToVideoFile(w http.ResponseWriter, r *http.Request) {
 //	vars := mux.Vars(r)
 //	seq, _ := strconv.ParseInt(vars["seq"], 10, 64)
@@ -506,212 +478,1475 @@ func (c *Controller) GetDailyVideoOld(w http.ResponseWriter, r *http.Request) {
 
 func (c *Controller) GetDailyM3u8(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	objectName := fmt.Sprintf("%s/%s/%s", vars["id"], vars["date"], common.LiveM3u8FileName)
-	object, err := common.MinioClient.GetObject(common.VideoRecordBucket, objectName, minio.GetObjectOptions{})
+	c.serveArchivedObject(w, r, vars["id"], vars["date"], common.LiveM3u8FileName)
+}
+
+func (c *Controller) GetDailyIframeM3u8(w http.ResponseWriter, r *http.Request) {
+	c.serveIframeM3u8(w, r, mux.Vars(r)["date"])
+}
+
+func (c *Controller) GetTodayIframeM3u8(w http.ResponseWriter, r *http.Request) {
+	c.serveIframeM3u8(w, r, time.Now().In(common.Loc).Format(common.DateFormat))
+}
+
+// serveIframeM3u8 serves the I-frame only playlist for fast-forward, rewind and thumbnails
+func (c *Controller) serveIframeM3u8(w http.ResponseWriter, r *http.Request, date string) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
 	if err != nil {
-		Response(w, r, err, http.StatusInternalServerError)
+		Response(w, r, err, http.StatusBadRequest)
 		return
 	}
-	buf := new(bytes.Buffer)
-	n, err := buf.ReadFrom(object)
+
+	playlist, err := c.manager.getIframePlaylist(streamId, date)
 	if err != nil {
+		if err == common.ErrorColdUnavailable {
+			Response(w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		if err == common.ErrorStreamNotFound {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err == common.ErrorNoVideos {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
 		Response(w, r, err, http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
-	w.WriteHeader(http.StatusOK)
-	w.Write(buf.Bytes())
+
+	data := playlist.Encode()
+	w.Header().Set("Content-Type", common.ContentTypeM3u8)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
 }
 
-func (c *Controller) GetDailyVideo(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	objectName := filepath.ToSlash(filepath.Join(vars["id"], vars["date"], vars["media"]+".ts"))
-	object, err := common.MinioClient.GetObject(common.VideoRecordBucket, objectName, minio.GetObjectOptions{})
+/*
+	curl -i "http://127.0.0.1:8000/videos/1/m3u8?from=2019-12-11T23:55:00%2B09:00&to=2019-12-12T00:10:00%2B09:00"
+*/
+func (c *Controller) GetRangeM3u8(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
 	if err != nil {
-		Response(w, r, err, http.StatusInternalServerError)
+		Response(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	buf := new(bytes.Buffer)
-	n, err := buf.ReadFrom(object)
+	if len(r.URL.Query().Get("from")) < 1 || len(r.URL.Query().Get("to")) < 1 {
+		Response(w, r, common.ErrorInvalidTime, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
 	if err != nil {
-		Response(w, r, err, http.StatusInternalServerError)
+		Response(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Accept-Range", "bytes")
-	w.Header().Set("Content-Type", common.ContentTypeTs)
-	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
-	w.WriteHeader(http.StatusOK)
-	w.Write(buf.Bytes())
+	playlist, err := c.manager.getRangePlaylist(streamId, from, to)
+	if err != nil {
+		if err == common.ErrorColdUnavailable {
+			Response(w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidTime {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err == common.ErrorNoVideos {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("annotations") == "daterange" {
+		if playlist.DateRanges, err = c.manager.getAnnotationDateRanges(streamId, from, to); err != nil {
+			Response(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
 
+	data := playlist.Encode()
+	w.Header().Set("Content-Type", common.ContentTypeM3u8)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
 }
 
-// Good example
-//func (c *Controller) GetDailyM3u8_old(w http.ResponseWriter, r *http.Request) {
-//	vars := mux.Vars(r)
-//	objectName := fmt.Sprintf("%s/%s/%s", vars["id"], vars["date"], "xxxx")
-//	object, err := common.MinioClient.GetObject(common.VideoRecordBucket, objectName, minio.GetObjectOptions{})
-//	if err != nil {
-//		log.WithFields(log.Fields{
-//			"bucket": common.VideoRecordBucket,
-//			"object": objectName,
-//		}).Debugf("failed to get object from Minio")
-//		Response(w, r, err, http.StatusInternalServerError)
-//		return
-//	}
-//
-//	w.Header().Set("Accept-Range", "bytes")
-//	//w.Header().Set("Content-Type", "video/vnd.dlna.mpeg-tts")
-//	//w.Header().Set("Content-Type", ContentTypeM3u8)
-//
-//	//if _, err = io.Copy(w, object); err != nil{
-//	//    Response(w, r,err, http.StatusInternalServerError)
-//	//    return
-//	//}
-//	buf := new(bytes.Buffer)
-//	n, err := buf.ReadFrom(object)
-//	if err != nil {
-//		Response(w, r, err, http.StatusInternalServerError)
-//		return
-//	}
-//	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
-//	w.WriteHeader(http.StatusOK)
-//	w.Write(buf.Bytes())
-//}
-
-//func (c *Controller) GetM3u8(w http.ResponseWriter, r *http.Request) {
-//	vars := mux.Vars(r)
-//	streamId, _ := strconv.ParseInt(vars["id"], 10, 64)
-//	date := vars["date"]
-//	bucket := []byte(fmt.Sprintf("stream-%d-%s", streamId, date))
-//	var maxTargetDuration float32
-//	var firstSeq int64
-//	//videos := make([]*VideoRecord, 0)
-//	//keys := make([]int64, 0)
-//
-//	body := ""
-//	err := DB.View(func(tx *bolt.Tx) error {
-//		// Assume bucket exists and has keys
-//		b := tx.Bucket(bucket)
-//		if b == nil {
-//			return nil
-//		}
-//
-//		c := b.Cursor()
-//
-//		for k, v := c.First(); k != nil; k, v = c.Next() {
-//			var videoRecord VideoRecord
-//			err := json.Unmarshal(v, &videoRecord)
-//			if err != nil {
-//				log.Error(err)
-//				continue
-//			}
-//
-//			if videoRecord.Duration > maxTargetDuration {
-//				maxTargetDuration = videoRecord.Duration
-//			}
-//			if firstSeq < 1 {
-//				firstSeq = BytesToInt64(k)
-//			}
-//
-//			body += fmt.Sprintf("#EXTINF:%.6f,\n", videoRecord.Duration)
-//			body += fmt.Sprintf("media%d.ts\n", BytesToInt64(k))
-//
-//			//keys = append(keys, BytesToInt64(k))
-//			//videos = append(videos, &videoRecord)
-//		}
-//		return nil
-//	})
-//	if err != nil {
-//		Response(w, r,err, http.StatusInternalServerError)
-//		return
-//	}
-//	//sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
-//	//sort.SliceStable(videos, func(i, j int) bool {
-//	//   return videos[i].UnixTime < videos[j].UnixTime
-//	//})
-//
-//	//for _, v := range videos {
-//	//if v.Duration > maxTargetDuration {
-//	//    maxTargetDuration = v.Duration
-//	//}
-//	//    body += fmt.Sprintf("#EXTINF:%.6f,\n", v.Duration)
-//	//    body += v.Url+ "\n"
-//	//}
-//	m3u8 := GetM3u8Header(firstSeq, math.Ceil(float64(maxTargetDuration))) + body + GetM3u8Footer()
-//	//w.Header().Set("Access-Control-Allow-Origin", "*")
-//	//w.Header().Set("Access-Control-Allow-Methods", "GET")
-//	//w.Header().Set("Cache-Control", "no-cache")
-//	w.Header().Set("Content-Length", strconv.Itoa(len(m3u8)))
-//	//w.Header().Set("Accept-Ranges", "bytes")
-//	//w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-//	w.WriteHeader(http.StatusOK)
-//
-//	// application/vnd.apple.mpegurl
-//
-//	//url, _ := url.Parse(url.QueryEscape(str))
-//	//if err != nil { panic(err) }
-//	//fmt.Println(url.String())
-//
-//	//fmt.Fprintf(w, m3u8)
-//	if _, err = w.Write([]byte(m3u8)); err != nil {
-//		log.Error(err)
-//	}
-//}
+/*
+	curl -i "http://127.0.0.1:8000/videos/sync?ids=1,2,3&from=2019-12-11T23:55:00%2B09:00&to=2019-12-12T00:10:00%2B09:00"
+*/
+func (c *Controller) GetSyncPlayback(w http.ResponseWriter, r *http.Request) {
+	ids := make([]int64, 0)
+	for _, str := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+		if err != nil {
+			Response(w, r, common.ErrorInvalidStream, http.StatusBadRequest)
+			return
+		}
+		if !canAccessStream(r, id, common.AclRecordings) {
+			Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+			return
+		}
+		ids = append(ids, id)
+	}
 
-//
-//func Download(file string, filename ...string) {
-//    // check get file error, file not found or other error.
-//    if _, err := os.Stat(file); err != nil {
-//        http.ServeFile(output.Context.ResponseWriter, output.Context.Request, file)
-//        return
-//    }
-//
-//    var fName string
-//    if len(filename) > 0 && filename[0] != "" {
-//        fName = filename[0]
-//    } else {
-//        fName = filepath.Base(file)
-//    }
-//    //https://tools.ietf.org/html/rfc6266#section-4.3
-//    fn := url.PathEscape(fName)
-//    if fName == fn {
-//        fn = "filename=" + fn
-//    } else {
-//        /**
-//          The parameters "filename" and "filename*" differ only in that
-//          "filename*" uses the encoding defined in [RFC5987], allowing the use
-//          of characters not present in the ISO-8859-1 character set
-//          ([ISO-8859-1]).
-//        */
-//        fn = "filename=" + fName + "; filename*=utf-8''" + fn
-//    }
-//    output.Header("Content-Disposition", "attachment; "+fn)
-//    output.Header("Content-Description", "File Transfer")
-//    output.Header("Content-Type", "application/octet-stream")
-//    output.Header("Content-Transfer-Encoding", "binary")
-//    output.Header("Expires", "0")
-//    output.Header("Cache-Control", "must-revalidate")
-//    output.Header("Pragma", "public")
-//    http.ServeFile(output.Context.ResponseWriter, output.Context.Request, file)
-//}
+	if len(r.URL.Query().Get("from")) < 1 || len(r.URL.Query().Get("to")) < 1 {
+		Response(w, r, common.ErrorInvalidTime, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
 
-//
+	playback, err := c.manager.getSyncPlayback(ids, from, to)
+	if err != nil {
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidStream || err == common.ErrorInvalidTime {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
 
-func formatAsDollars(valueInCents int) (string, error) {
-	dollars := valueInCents / 100
-	cents := valueInCents % 100
-	return fmt.Sprintf("$%d.%2d", dollars, cents), nil
+	writeJson(w, r, playback)
 }
 
-func formatAsDate(t time.Time) string {
-	year, month, day := t.Date()
-	return fmt.Sprintf("%d/%d/%d", day, month, year)
-}
+func (c *Controller) GetSyncM3u8(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
 
-func urgentNote(acc ui.Account) string {
-	return fmt.Sprintf("You have earned 100 VIP points that can be used for purchases")
+	if len(r.URL.Query().Get("from")) < 1 || len(r.URL.Query().Get("to")) < 1 {
+		Response(w, r, common.ErrorInvalidTime, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) || to.Sub(from) > maxPlaylistDuration {
+		Response(w, r, common.ErrorInvalidTime, http.StatusBadRequest)
+		return
+	}
+	if c.manager.getStreamById(streamId) == nil {
+		Response(w, r, common.ErrorStreamNotFound, http.StatusBadRequest)
+		return
+	}
+
+	playlist, err := c.manager.getSyncPlaylist(streamId, from, to)
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("annotations") == "daterange" {
+		if playlist.DateRanges, err = c.manager.getAnnotationDateRanges(streamId, from, to); err != nil {
+			Response(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := playlist.Encode()
+	w.Header().Set("Content-Type", common.ContentTypeM3u8)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+func (c *Controller) GetFillerVideo(w http.ResponseWriter, r *http.Request) {
+	seconds, _ := strconv.Atoi(mux.Vars(r)["seconds"])
+	path, err := c.manager.getFillerVideo(seconds)
+	if err != nil {
+		if err == common.ErrorInvalidTime {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.ContentTypeTs)
+	http.ServeFile(w, r, path)
+}
+
+/*
+	curl -i -X POST -d '{"from":1576076400,"to":1576076460,"label":"Door forced","tags":["door"],"source":"access-control"}' http://127.0.0.1:8000/videos/1/annotations
+*/
+func (c *Controller) AddAnnotation(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var annotation common.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if len(annotation.Author) < 1 {
+		annotation.Author = requestPrincipal(r).name()
+	}
+	if err := c.manager.addAnnotation(streamId, &annotation); err != nil {
+		responseAnnotationError(w, r, err)
+		return
+	}
+
+	writeJson(w, r, annotation)
+}
+
+func (c *Controller) GetAnnotation(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	id, _ := strconv.ParseInt(mux.Vars(r)["annotationId"], 10, 64)
+
+	annotation, err := c.manager.getAnnotation(streamId, id)
+	if err != nil {
+		responseAnnotationError(w, r, err)
+		return
+	}
+
+	writeJson(w, r, annotation)
+}
+
+func (c *Controller) UpdateAnnotation(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	id, _ := strconv.ParseInt(mux.Vars(r)["annotationId"], 10, 64)
+
+	var annotation common.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err := c.manager.updateAnnotation(streamId, id, &annotation); err != nil {
+		responseAnnotationError(w, r, err)
+		return
+	}
+
+	writeJson(w, r, annotation)
+}
+
+func (c *Controller) DeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	id, _ := strconv.ParseInt(mux.Vars(r)["annotationId"], 10, 64)
+
+	if err := c.manager.deleteAnnotation(streamId, id); err != nil {
+		responseAnnotationError(w, r, err)
+		return
+	}
+
+	Response(w, r, nil, http.StatusOK)
+}
+
+/*
+	curl -i "http://127.0.0.1:8000/annotations?ids=1,2&tag=door&q=forced&from=20191211&to=20191212"
+*/
+func (c *Controller) SearchAnnotations(w http.ResponseWriter, r *http.Request) {
+	ids := make([]int64, 0)
+	if str := r.URL.Query().Get("ids"); len(str) > 0 {
+		for _, s := range strings.Split(str, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				Response(w, r, common.ErrorInvalidStream, http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+	if _, ok := mux.Vars(r)["id"]; ok {
+		streamId, err := streaming.ParseAndGetStreamId(r)
+		if err != nil {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		ids = []int64{streamId}
+	}
+	for _, id := range ids {
+		if !canAccessStream(r, id, common.AclRecordings) {
+			Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+			return
+		}
+	}
+	if len(ids) < 1 {
+		if ids = c.accessibleStreamIds(r, common.AclRecordings); len(ids) < 1 {
+			writeJson(w, r, []*common.Annotation{})
+			return
+		}
+	}
+
+	filter, err := parseAnnotationFilter(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	annotations, err := c.manager.searchAnnotations(ids, filter)
+	if err != nil {
+		responseAnnotationError(w, r, err)
+		return
+	}
+
+	writeJson(w, r, annotations)
+}
+
+// GetAnnotationVtt returns annotations as WebVTT aligned with the time range playlist
+func (c *Controller) GetAnnotationVtt(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if len(r.URL.Query().Get("from")) < 1 || len(r.URL.Query().Get("to")) < 1 {
+		Response(w, r, common.ErrorInvalidTime, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	data, err := c.manager.getAnnotationVtt(streamId, from, to)
+	if err != nil {
+		responseAnnotationError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.ContentTypeVtt)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// parseAnnotationFilter reads "from", "to", "tag", "source", "author" and "q" query parameters
+func parseAnnotationFilter(r *http.Request) (*common.AnnotationFilter, error) {
+	query := r.URL.Query()
+	filter := &common.AnnotationFilter{
+		Tag:    query.Get("tag"),
+		Source: query.Get("source"),
+		Author: query.Get("author"),
+		Query:  query.Get("q"),
+	}
+	if str := query.Get("from"); len(str) > 0 {
+		t, err := common.ParseTime(str)
+		if err != nil {
+			return nil, err
+		}
+		filter.From = t.Unix()
+	}
+	if str := query.Get("to"); len(str) > 0 {
+		t, err := common.ParseTime(str)
+		if err != nil {
+			return nil, err
+		}
+		filter.To = t.Unix()
+	}
+	return filter, nil
+}
+
+func responseAnnotationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case common.ErrorStreamNotFound, common.ErrorInvalidTime, common.ErrorInvalidAnnotation:
+		Response(w, r, err, http.StatusBadRequest)
+	case common.ErrorAnnotationNotFound:
+		Response(w, r, err, http.StatusNotFound)
+	default:
+		Response(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+	curl -i "http://127.0.0.1:8000/videos/1/timeline?from=2019-12-11T00:00:00%2B09:00&to=2019-12-12T00:00:00%2B09:00"
+*/
+func (c *Controller) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	timeline, err := c.manager.getTimeline(streamId, from, to)
+	if err != nil {
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidTime {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.MarshalIndent(timeline, "", "  ")
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.ContentTypeJson)
+	w.Write(data)
+}
+
+// parseTimeRange reads "from" and "to" query parameters; today is used by default
+func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now().In(common.Loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, common.Loc)
+	to := from.AddDate(0, 0, 1)
+
+	var err error
+	if str := r.URL.Query().Get("from"); len(str) > 0 {
+		if from, err = common.ParseTime(str); err != nil {
+			return from, to, err
+		}
+		to = from.AddDate(0, 0, 1)
+	}
+	if str := r.URL.Query().Get("to"); len(str) > 0 {
+		if to, err = common.ParseTime(str); err != nil {
+			return from, to, err
+		}
+	}
+	if !from.Before(to) {
+		return from, to, common.ErrorInvalidTime
+	}
+	return from, to, nil
+}
+
+func (c *Controller) VerifyDailyVideos(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	report, err := c.manager.verifyVideos(streamId, mux.Vars(r)["date"])
+	if err != nil {
+		if err == common.ErrorColdUnavailable {
+			Response(w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidDate {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.ContentTypeJson)
+	w.Write(data)
+}
+
+func (c *Controller) GetChainStatus(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	status, err := c.manager.getChainStatus(streamId, mux.Vars(r)["date"])
+	if err != nil {
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidDate {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, r, status)
+}
+
+func (c *Controller) GetDiskStatus(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, r, c.manager.getWatchdogStatus())
+}
+
+// ProtectDailyVideos protects(POST) or unprotects(DELETE) the archived day
+func (c *Controller) ProtectDailyVideos(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	date := mux.Vars(r)["date"]
+	err = c.manager.updateDayArchive(streamId, date, func(day *common.DayArchive) {
+		day.Protected = r.Method == http.MethodPost
+	})
+	if err != nil {
+		if err == common.ErrorNoVideos {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	Response(w, r, nil, http.StatusOK)
+}
+
+// RotateEncryptionKey generates a new master key; archived videos are encrypted with it from now on
+func (c *Controller) RotateEncryptionKey(w http.ResponseWriter, r *http.Request) {
+	if c.server.keys == nil {
+		Response(w, r, common.ErrorNoEncryption, http.StatusBadRequest)
+		return
+	}
+
+	id, err := c.server.keys.Rotate()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{
+		"keyId": id,
+	}).Info("[controller] encryption key has been rotated")
+	writeJson(w, r, map[string]uint32{"keyId": id})
+}
+
+func (c *Controller) GetUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := c.manager.getUsage()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, r, usage)
+}
+
+func (c *Controller) GetArchiveProgress(w http.ResponseWriter, r *http.Request) {
+	progress, err := c.manager.getArchiveProgress()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	list := make([]*common.ArchiveProgress, 0, len(progress))
+	for _, p := range progress {
+		if canAccessStream(r, p.StreamId, common.AclControl) {
+			list = append(list, p)
+		}
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.ContentTypeJson)
+	w.Write(data)
+}
+
+/*
+	curl -i -X POST "http://127.0.0.1:8000/videos/1/archive?from=20191211&to=20191212"
+*/
+func (c *Controller) ArchiveVideos(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := c.manager.addArchiveJobs(streamId, from, to)
+	if err != nil {
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidTime {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, r, jobs)
+}
+
+// GetArchiveJobs returns archive jobs filtered by stream id(id) and status
+func (c *Controller) GetArchiveJobs(w http.ResponseWriter, r *http.Request) {
+	var streamId int64
+	if str := r.URL.Query().Get("id"); len(str) > 0 {
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		streamId = id
+	}
+	status := r.URL.Query().Get("status")
+
+	jobs, err := c.manager.jobs.list(func(job *common.Job) bool {
+		if job.Type != common.JobArchive {
+			return false
+		}
+		if (streamId > 0 && job.StreamId != streamId) || !canAccessStream(r, job.StreamId, common.AclControl) {
+			return false
+		}
+		return len(status) < 1 || job.Status == status
+	})
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, r, jobs)
+}
+
+// Jobs which can be handled by routes of archive jobs and exports
+var (
+	archiveJobTypes = []string{common.JobArchive, common.JobMove, common.JobTranscode}
+	exportJobTypes  = []string{common.JobExport}
+)
+
+func (c *Controller) GetArchiveJob(w http.ResponseWriter, r *http.Request) {
+	c.handleArchiveJob(w, r, archiveJobTypes, c.manager.jobs.get)
+}
+
+func (c *Controller) CancelArchiveJob(w http.ResponseWriter, r *http.Request) {
+	c.handleArchiveJob(w, r, archiveJobTypes, c.manager.jobs.cancel)
+}
+
+func (c *Controller) RetryArchiveJob(w http.ResponseWriter, r *http.Request) {
+	c.handleArchiveJob(w, r, archiveJobTypes, c.manager.jobs.retry)
+}
+
+func (c *Controller) GetExportJob(w http.ResponseWriter, r *http.Request) {
+	c.handleArchiveJob(w, r, exportJobTypes, c.manager.jobs.get)
+}
+
+func (c *Controller) CancelExportJob(w http.ResponseWriter, r *http.Request) {
+	c.handleArchiveJob(w, r, exportJobTypes, c.manager.jobs.cancel)
+}
+
+func isJobTypeOf(job *common.Job, types []string) bool {
+	for _, t := range types {
+		if job.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// getJobAction returns the permission needed for the job
+func getJobAction(job *common.Job) string {
+	if job.Type == common.JobExport {
+		return common.AclExport
+	}
+	return common.AclControl
+}
+
+// handleArchiveJob handles the job if it's one of the types; jobs of other types are not found on the route
+func (c *Controller) handleArchiveJob(w http.ResponseWriter, r *http.Request, types []string, f func(id int64) (*common.Job, error)) {
+	jobId, err := strconv.ParseInt(mux.Vars(r)["jobId"], 10, 64)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	job, err := c.manager.jobs.get(jobId)
+	if err == nil && !isJobTypeOf(job, types) {
+		err = common.ErrorJobNotFound
+	}
+	if err == nil {
+		if !canAccessStream(r, job.StreamId, getJobAction(job)) {
+			Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+			return
+		}
+		job, err = f(jobId)
+	}
+	if err != nil {
+		switch err {
+		case common.ErrorJobNotFound:
+			Response(w, r, err, http.StatusNotFound)
+		case common.ErrorInvalidJobStatus:
+			Response(w, r, err, http.StatusBadRequest)
+		default:
+			Response(w, r, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJson(w, r, job)
+}
+
+/*
+	curl -i -X POST "http://127.0.0.1:8000/videos/1/export?from=2019-12-11T23:50:00%2B09:00&to=2019-12-12T00:10:00%2B09:00"
+*/
+func (c *Controller) ExportVideos(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if len(r.URL.Query().Get("from")) < 1 || len(r.URL.Query().Get("to")) < 1 {
+		Response(w, r, common.ErrorInvalidTime, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if len(format) < 1 {
+		format = common.ExportMp4
+	}
+	if format != common.ExportMp4 && format != common.ExportBundle {
+		Response(w, r, common.ErrorInvalidFormat, http.StatusBadRequest)
+		return
+	}
+
+	job, err := c.manager.addExportJob(streamId, from, to, format)
+	if err != nil {
+		if err == common.ErrorStreamNotFound || err == common.ErrorInvalidTime {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, r, job)
+}
+
+func (c *Controller) GetExportJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := c.manager.jobs.list(func(job *common.Job) bool {
+		return job.Type == common.JobExport && canAccessStream(r, job.StreamId, common.AclExport)
+	})
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, r, jobs)
+}
+
+func (c *Controller) DownloadExportedVideo(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.ParseInt(mux.Vars(r)["jobId"], 10, 64)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	job, path, err := c.manager.getExportedFile(jobId)
+	if err != nil {
+		switch err {
+		case common.ErrorJobNotFound:
+			Response(w, r, err, http.StatusNotFound)
+		case common.ErrorExpired:
+			Response(w, r, err, http.StatusGone)
+		case common.ErrorInvalidJobStatus:
+			Response(w, r, err, http.StatusBadRequest)
+		default:
+			Response(w, r, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	if !canAccessStream(r, job.StreamId, common.AclExport) {
+		Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+		return
+	}
+
+	name := fmt.Sprintf("stream-%d-%s%s", job.StreamId, time.Unix(job.From, 0).In(common.Loc).Format("20060102T150405"), filepath.Ext(path))
+	contentType := common.ContentTypeMp4
+	if job.Format == common.ExportBundle {
+		contentType = common.ContentTypeZip
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
+
+// GetExportPublicKey returns the public key to verify evidence bundles
+func (c *Controller) GetExportPublicKey(w http.ResponseWriter, r *http.Request) {
+	key, err := c.manager.getPublicKey()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, r, map[string]string{"publicKey": key})
+}
+
+func writeJson(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.ContentTypeJson)
+	w.Write(data)
+}
+
+func (c *Controller) GetDailyVideo(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c.serveArchivedObject(w, r, vars["id"], vars["date"], vars["media"]+common.VideoFileExt)
+}
+
+// serveArchivedObject streams the archived file from the storage tier which holds the day.
+// Objects are not buffered; range requests are served by seeking.
+func (c *Controller) serveArchivedObject(w http.ResponseWriter, r *http.Request, id, date, name string) {
+	streamId, _ := strconv.ParseInt(id, 10, 64)
+	backend, err := c.manager.getBackend(streamId, date)
+	if err != nil {
+		if err == common.ErrorColdUnavailable {
+			Response(w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	key := storage.Key(id, date, name)
+	info, err := backend.Stat(key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", common.DetectContentType(name))
+	if len(info.ETag) > 0 {
+		etag := strconv.Quote(info.ETag)
+		w.Header().Set("ETag", etag)
+		if isNotModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	object, err := backend.Get(key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	// Conditional and range requests are handled by ServeContent
+	http.ServeContent(w, r, name, info.ModTime, object)
+}
+
+// isNotModified checks If-None-Match before the object is opened
+func isNotModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, str := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		str = strings.TrimPrefix(strings.TrimSpace(str), "W/")
+		if str == "*" || str == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Good example
+//func (c *Controller) GetDailyM3u8_old(w http.ResponseWriter, r *http.Request) {
+//	vars := mux.Vars(r)
+//	objectName := fmt.Sprintf("%s/%s/%s", vars["id"], vars["date"], "xxxx")
+//	object, err := common.MinioClient.GetObject(common.VideoRecordBucket, objectName, minio.GetObjectOptions{})
+//	if err != nil {
+//		log.WithFields(log.Fields{
+//			"bucket": common.VideoRecordBucket,
+//			"object": objectName,
+//		}).Debugf("failed to get object from Minio")
+//		Response(w, r, err, http.StatusInternalServerError)
+//		return
+//	}
+//
+//	w.Header().Set("Accept-Range", "bytes")
+//	//w.Header().Set("Content-Type", "video/vnd.dlna.mpeg-tts")
+//	//w.Header().Set("Content-Type", ContentTypeM3u8)
+//
+//	//if _, err = io.Copy(w, object); err != nil{
+//	//    Response(w, r,err, http.StatusInternalServerError)
+//	//    return
+//	//}
+//	buf := new(bytes.Buffer)
+//	n, err := buf.ReadFrom(object)
+//	if err != nil {
+//		Response(w, r, err, http.StatusInternalServerError)
+//		return
+//	}
+//	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+//	w.WriteHeader(http.StatusOK)
+//	w.Write(buf.Bytes())
+//}
+
+//func (c *Controller) GetM3u8(w http.ResponseWriter, r *http.Request) {
+//	vars := mux.Vars(r)
+//	streamId, _ := strconv.ParseInt(vars["id"], 10, 64)
+//	date := vars["date"]
+//	bucket := []byte(fmt.Sprintf("stream-%d-%s", streamId, date))
+//	var maxTargetDuration float32
+//	var firstSeq int64
+//	//videos := make([]*VideoRecord, 0)
+//	//keys := make([]int64, 0)
+//
+//	body := ""
+//	err := DB.View(func(tx *bolt.Tx) error {
+//		// Assume bucket exists and has keys
+//		b := tx.Bucket(bucket)
+//		if b == nil {
+//			return nil
+//		}
+//
+//		c := b.Cursor()
+//
+//		for k, v := c.First(); k != nil; k, v = c.Next() {
+//			var videoRecord VideoRecord
+//			err := json.Unmarshal(v, &videoRecord)
+//			if err != nil {
+//				log.Error(err)
+//				continue
+//			}
+//
+//			if videoRecord.Duration > maxTargetDuration {
+//				maxTargetDuration = videoRecord.Duration
+//			}
+//			if firstSeq < 1 {
+//				firstSeq = BytesToInt64(k)
+//			}
+//
+//			body += fmt.Sprintf("#EXTINF:%.6f,\n", videoRecord.Duration)
+//			body += fmt.Sprintf("media%d.ts\n", BytesToInt64(k))
+//
+//			//keys = append(keys, BytesToInt64(k))
+//			//videos = append(videos, &videoRecord)
+//		}
+//		return nil
+//	})
+//	if err != nil {
+//		Response(w, r,err, http.StatusInternalServerError)
+//		return
+//	}
+//	//sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+//	//sort.SliceStable(videos, func(i, j int) bool {
+//	//   return videos[i].UnixTime < videos[j].UnixTime
+//	//})
+//
+//	//for _, v := range videos {
+//	//if v.Duration > maxTargetDuration {
+//	//    maxTargetDuration = v.Duration
+//	//}
+//	//    body += fmt.Sprintf("#EXTINF:%.6f,\n", v.Duration)
+//	//    body += v.Url+ "\n"
+//	//}
+//	m3u8 := GetM3u8Header(firstSeq, math.Ceil(float64(maxTargetDuration))) + body + GetM3u8Footer()
+//	//w.Header().Set("Access-Control-Allow-Origin", "*")
+//	//w.Header().Set("Access-Control-Allow-Methods", "GET")
+//	//w.Header().Set("Cache-Control", "no-cache")
+//	w.Header().Set("Content-Length", strconv.Itoa(len(m3u8)))
+//	//w.Header().Set("Accept-Ranges", "bytes")
+//	//w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+//	w.WriteHeader(http.StatusOK)
+//
+//	// application/vnd.apple.mpegurl
+//
+//	//url, _ := url.Parse(url.QueryEscape(str))
+//	//if err != nil { panic(err) }
+//	//fmt.Println(url.String())
+//
+//	//fmt.Fprintf(w, m3u8)
+//	if _, err = w.Write([]byte(m3u8)); err != nil {
+//		log.Error(err)
+//	}
+//}
+
+//
+//func Download(file string, filename ...string) {
+//    // check get file error, file not found or other error.
+//    if _, err := os.Stat(file); err != nil {
+//        http.ServeFile(output.Context.ResponseWriter, output.Context.Request, file)
+//        return
+//    }
+//
+//    var fName string
+//    if len(filename) > 0 && filename[0] != "" {
+//        fName = filename[0]
+//    } else {
+//        fName = filepath.Base(file)
+//    }
+//    //https://tools.ietf.org/html/rfc6266#section-4.3
+//    fn := url.PathEscape(fName)
+//    if fName == fn {
+//        fn = "filename=" + fn
+//    } else {
+//        /**
+//          The parameters "filename" and "filename*" differ only in that
+//          "filename*" uses the encoding defined in [RFC5987], allowing the use
+//          of characters not present in the ISO-8859-1 character set
+//          ([ISO-8859-1]).
+//        */
+//        fn = "filename=" + fName + "; filename*=utf-8''" + fn
+//    }
+//    output.Header("Content-Disposition", "attachment; "+fn)
+//    output.Header("Content-Description", "File Transfer")
+//    output.Header("Content-Type", "application/octet-stream")
+//    output.Header("Content-Transfer-Encoding", "binary")
+//    output.Header("Expires", "0")
+//    output.Header("Cache-Control", "must-revalidate")
+//    output.Header("Pragma", "public")
+//    http.ServeFile(output.Context.ResponseWriter, output.Context.Request, file)
+//}
+
+//
+
+func formatAsDollars(valueInCents int) (string, error) {
+	dollars := valueInCents / 100
+	cents := valueInCents % 100
+	return fmt.Sprintf("$%d.%2d", dollars, cents), nil
+}
+
+func formatAsDate(t time.Time) string {
+	year, month, day := t.Date()
+	return fmt.Sprintf("%d/%d/%d", day, month, year)
+}
+
+func urgentNote(acc ui.Account) string {
+	return fmt.Sprintf("You have earned 100 VIP points that can be used for purchases")
+}
+
+func (c *Controller) DisplayLogin(w http.ResponseWriter, r *http.Request) {
+	c.displayLogin(w, r, r.URL.Query().Get("next"), nil)
+}
+
+func (c *Controller) displayLogin(w http.ResponseWriter, r *http.Request, next string, loginErr error) {
+	tmpl, err := template.New("login").Parse(ui.LoginPage())
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"next": safeRedirect(next),
+	}
+	if loginErr != nil {
+		data["error"] = loginErr.Error()
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+	curl -i -c cookie.txt -X POST -H "Content-Type: application/json" -d '{"username":"admin","password":"xxxx"}' http://127.0.0.1:8000/login
+*/
+func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
+	isJson := strings.HasPrefix(r.Header.Get("Content-Type"), common.ContentTypeJson)
+	var form struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if isJson {
+		if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+	} else {
+		form.Username = r.PostFormValue("username")
+		form.Password = r.PostFormValue("password")
+	}
+
+	user, err := authenticateUser(form.Username, form.Password)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ip":       r.RemoteAddr,
+			"username": form.Username,
+		}).Warn("[controller] login failed")
+		if err != common.ErrorLoginFailed {
+			Response(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if isJson {
+			Response(w, r, err, http.StatusUnauthorized)
+			return
+		}
+		c.displayLogin(w, r, r.PostFormValue("next"), err)
+		return
+	}
+
+	token, session, err := createSession(user.Username, time.Duration(c.server.config.Auth.SessionTimeout)*time.Minute)
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(session.Expires, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if isJson {
+		writeJson(w, r, user)
+		return
+	}
+	http.Redirect(w, r, safeRedirect(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+func (c *Controller) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := deleteSession(cookie.Value); err != nil {
+			Response(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	if strings.HasPrefix(r.Header.Get("Content-Type"), common.ContentTypeJson) {
+		Response(w, r, nil, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (c *Controller) GetMe(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, r, requestPrincipal(r).user)
+}
+
+/*
+	curl -i -b cookie.txt -X PATCH -d '{"password":"current password","newPassword":"new password"}' http://127.0.0.1:8000/me/password
+*/
+func (c *Controller) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var form struct {
+		Password    string `json:"password"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	username := requestPrincipal(r).user.Username
+	if _, err := authenticateUser(username, form.Password); err != nil {
+		responseUserError(w, r, err)
+		return
+	}
+	if _, err := updateUser(username, "", form.NewPassword); err != nil {
+		responseUserError(w, r, err)
+		return
+	}
+	Response(w, r, nil, http.StatusOK)
+}
+
+func (c *Controller) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := getUsers()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, r, users)
+}
+
+/*
+	curl -i -b cookie.txt -X POST -d '{"username":"kim","password":"xxxxxxxx","role":"operator"}' http://127.0.0.1:8000/users
+*/
+func (c *Controller) AddUser(w http.ResponseWriter, r *http.Request) {
+	var form struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	user := &common.User{Username: form.Username, Role: form.Role}
+	if err := addUser(user, form.Password); err != nil {
+		responseUserError(w, r, err)
+		return
+	}
+	user.Password = nil
+	writeJson(w, r, user)
+}
+
+// UpdateUser changes the role or resets the password of the user
+func (c *Controller) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var form struct {
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	user, err := updateUser(mux.Vars(r)["username"], form.Role, form.Password)
+	if err != nil {
+		responseUserError(w, r, err)
+		return
+	}
+	writeJson(w, r, user)
+}
+
+/*
+	curl -i -b cookie.txt -X PUT -d '{"restricted":true,"acl":[{"group":"tenant-a","permissions":["live","recordings"]},{"streamId":3,"permissions":["live"]}]}' http://127.0.0.1:8000/users/kim/acl
+*/
+func (c *Controller) SetUserAcl(w http.ResponseWriter, r *http.Request) {
+	var form struct {
+		Restricted bool               `json:"restricted"`
+		Acl        []*common.AclEntry `json:"acl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	user, err := c.manager.setUserAcl(mux.Vars(r)["username"], form.Restricted, form.Acl)
+	if err != nil {
+		if err == common.ErrorInvalidAcl || err == common.ErrorStreamNotFound {
+			Response(w, r, err, http.StatusBadRequest)
+			return
+		}
+		responseUserError(w, r, err)
+		return
+	}
+	writeJson(w, r, user)
+}
+
+func (c *Controller) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := deleteUser(mux.Vars(r)["username"]); err != nil {
+		responseUserError(w, r, err)
+		return
+	}
+	Response(w, r, nil, http.StatusOK)
+}
+
+func (c *Controller) GetTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := getTokens()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, r, tokens)
+}
+
+/*
+	curl -i -b cookie.txt -X POST -d '{"name":"vms","scopes":["streams:read","media:read"],"streams":[1,2],"expires":1609426800}' http://127.0.0.1:8000/tokens
+*/
+func (c *Controller) AddToken(w http.ResponseWriter, r *http.Request) {
+	var t common.ApiToken
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	t.CreatedBy = requestPrincipal(r).name()
+	token, err := c.manager.addToken(&t)
+	if err != nil {
+		switch err {
+		case common.ErrorInvalidToken, common.ErrorStreamNotFound:
+			Response(w, r, err, http.StatusBadRequest)
+		default:
+			Response(w, r, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The token is shown only once
+	writeJson(w, r, map[string]interface{}{
+		"token":    token,
+		"apiToken": t,
+	})
+}
+
+func (c *Controller) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["tokenId"], 10, 64)
+	if err := deleteToken(id); err != nil {
+		if err == common.ErrorTokenNotFound {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	Response(w, r, nil, http.StatusOK)
+}
+
+/*
+	curl -i -b cookie.txt -X POST -d '{"from":1576076400,"to":1576077000,"expires":1576162800}' http://127.0.0.1:8000/videos/1/shares
+	curl -i -b cookie.txt -X POST -d '{"live":true}' http://127.0.0.1:8000/videos/1/shares
+*/
+func (c *Controller) AddShare(w http.ResponseWriter, r *http.Request) {
+	streamId, err := streaming.ParseAndGetStreamId(r)
+	if err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var share common.Share
+	if err := json.NewDecoder(r.Body).Decode(&share); err != nil {
+		Response(w, r, err, http.StatusBadRequest)
+		return
+	}
+	action := common.AclRecordings
+	if share.Live {
+		action = common.AclLive
+	}
+	if !canAccessStream(r, streamId, action) {
+		Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+		return
+	}
+	share.StreamId = streamId
+	share.CreatedBy = requestPrincipal(r).name()
+	if err := c.manager.addShare(&share); err != nil {
+		switch err {
+		case common.ErrorStreamNotFound, common.ErrorInvalidTime, common.ErrorInvalidShare:
+			Response(w, r, err, http.StatusBadRequest)
+		default:
+			Response(w, r, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJson(w, r, map[string]interface{}{
+		"share": share,
+		"url":   c.server.signShareUrl(getSharePath(&share)+"/m3u8", share.Expires),
+	})
+}
+
+func (c *Controller) GetShares(w http.ResponseWriter, r *http.Request) {
+	shares, err := getShares()
+	if err != nil {
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	list := make([]*common.Share, 0, len(shares))
+	for _, s := range shares {
+		if canAccessStream(r, s.StreamId, common.AclExport) {
+			list = append(list, s)
+		}
+	}
+	writeJson(w, r, list)
+}
+
+func (c *Controller) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["shareId"], 10, 64)
+	share, err := getShare(id)
+	if err == nil && !canAccessStream(r, share.StreamId, common.AclExport) {
+		Response(w, r, common.ErrorForbidden, http.StatusForbidden)
+		return
+	}
+	if err == nil {
+		share, err = revokeShare(id)
+	}
+	if err != nil {
+		if err == common.ErrorShareNotFound {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, r, share)
+}
+
+// verifyShare checks the signature of shared URLs which don't need a login
+func (c *Controller) verifyShare(h func(w http.ResponseWriter, r *http.Request, share *common.Share)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shareId, _ := strconv.ParseInt(mux.Vars(r)["shareId"], 10, 64)
+		share, err := c.server.verifyShareUrl(r.URL, shareId)
+		if err != nil {
+			if err == common.ErrorInvalidSignature {
+				Response(w, r, err, http.StatusForbidden)
+				return
+			}
+			Response(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		h(w, r, share)
+	}
+}
+
+func (c *Controller) GetSharedM3u8(w http.ResponseWriter, r *http.Request, share *common.Share) {
+	playlist, err := c.manager.getSharePlaylist(share)
+	if err != nil {
+		if err == common.ErrorColdUnavailable {
+			Response(w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		if err == common.ErrorNoVideos {
+			Response(w, r, err, http.StatusNotFound)
+			return
+		}
+		Response(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	data := playlist.Encode()
+	w.Header().Set("Content-Type", common.ContentTypeM3u8)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// GetSharedLiveVideo serves live and today's videos of the share
+func (c *Controller) GetSharedLiveVideo(w http.ResponseWriter, r *http.Request, share *common.Share) {
+	path := filepath.Join(c.server.config.Storage.LiveDir, strconv.FormatInt(share.StreamId, 10), mux.Vars(r)["media"]+common.VideoFileExt)
+	http.ServeFile(w, r, path)
+}
+
+func (c *Controller) GetSharedDailyVideo(w http.ResponseWriter, r *http.Request, share *common.Share) {
+	vars := mux.Vars(r)
+	c.serveArchivedObject(w, r, strconv.FormatInt(share.StreamId, 10), vars["date"], vars["media"]+common.VideoFileExt)
+}
+
+// accessibleStreamIds returns ids of the streams on which the principal of the request can do the action
+func (c *Controller) accessibleStreamIds(r *http.Request, action string) []int64 {
+	ids := make([]int64, 0)
+	for _, id := range c.manager.getStreamIdList() {
+		if canAccessStream(r, id, action) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func responseUserError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case common.ErrorInvalidUser, common.ErrorInvalidPassword, common.ErrorDuplicatedUser, common.ErrorLastAdmin:
+		Response(w, r, err, http.StatusBadRequest)
+	case common.ErrorLoginFailed:
+		Response(w, r, err, http.StatusUnauthorized)
+	case common.ErrorUserNotFound:
+		Response(w, r, err, http.StatusNotFound)
+	default:
+		Response(w, r, err, http.StatusInternalServerError)
+	}
 }
 
 func serveTemplate2(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"github.com/devplayg/rtsp-stream/common"
+	"syscall"
+)
+
+func getDiskStatus(path string) (*common.DiskStatus, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	return &common.DiskStatus{
+		Path:       path,
+		TotalBytes: uint64(st.Blocks) * uint64(st.Bsize),
+		FreeBytes:  uint64(st.Bavail) * uint64(st.Bsize),
+		Inodes:     uint64(st.Files),
+		FreeInodes: uint64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/devplayg/rtsp-stream/common"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// getDiskStatus returns free space only; inodes are not available on Windows
+func getDiskStatus(path string) (*common.DiskStatus, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var available, total, free uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&available)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&free)),
+	)
+	if r == 0 {
+		return nil, err
+	}
+	return &common.DiskStatus{
+		Path:       path,
+		TotalBytes: total,
+		FreeBytes:  available,
+	}, nil
+}
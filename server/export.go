@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/devplayg/hippo"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Exports longer than this are not allowed
+const maxExportDuration = 24 * time.Hour
+
+// exportSource is a video file to be concatenated
+type exportSource struct {
+	path     string
+	start    time.Time
+	duration float64 // seconds
+}
+
+func (s *exportSource) end() time.Time {
+	return s.start.Add(time.Duration(s.duration * float64(time.Second)))
+}
+
+func (m *Manager) addExportJob(streamId int64, from, to time.Time, format string) (*common.Job, error) {
+	if m.getStreamById(streamId) == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+	if !from.Before(to) || to.Sub(from) > maxExportDuration {
+		return nil, common.ErrorInvalidTime
+	}
+
+	job := common.NewJob(common.JobExport, streamId, from, to, 1)
+	job.Format = format
+	if err := m.jobs.add(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// runExportJob concatenates archived and live videos in the time range into a MP4 file
+func (m *Manager) runExportJob(ctx context.Context, job *common.Job) error {
+	from := time.Unix(job.From, 0).In(common.Loc)
+	to := time.Unix(job.To, 0).In(common.Loc)
+
+	workDir := filepath.Join(m.server.config.Export.Dir, ".work", strconv.FormatInt(job.Id, 10))
+	if err := os.RemoveAll(workDir); err != nil {
+		return err
+	}
+	if err := hippo.EnsureDir(workDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	sources, err := m.getExportSources(job.StreamId, from, to, workDir)
+	if err != nil {
+		return err
+	}
+	if len(sources) < 1 {
+		return common.ErrorNoVideos
+	}
+	job.Result = &common.JobResult{InputFiles: len(sources)}
+	for _, src := range sources {
+		if f, err := os.Stat(src.path); err == nil {
+			job.Result.InputBytes += f.Size()
+		}
+	}
+
+	listFilePath := filepath.Join(workDir, "list.txt")
+	if err := writeExportListToText(listFilePath, sources); err != nil {
+		return err
+	}
+
+	offset, duration := getExportCut(sources, from, to)
+
+	tmpPath := filepath.Join(workDir, "export.mp4")
+	output, err := ExportVideo(ctx, listFilePath, tmpPath, offset, duration, false)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"jobId": job.Id,
+		}).Debug("[manager] failed to remux videos; transcoding..")
+		output, err = ExportVideo(ctx, listFilePath, tmpPath, offset, duration, true)
+		if err != nil {
+			job.Result.Output = getOutputTail(output)
+			return err
+		}
+	}
+
+	path := m.getExportFilePath(job)
+	if job.Format == common.ExportBundle {
+		if err := m.writeEvidenceBundle(job, tmpPath, sources, path); err != nil {
+			return err
+		}
+	} else if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	f, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	job.Result.OutputFiles = 1
+	job.Result.OutputBytes = f.Size()
+	job.Result.OutputDuration = duration
+	job.Result.File = filepath.Base(path)
+	job.Result.Expires = time.Now().Add(time.Duration(m.server.config.Export.Expiry) * time.Hour).Unix()
+	return nil
+}
+
+// getExportSources returns archived and live videos overlapping the time range in order
+func (m *Manager) getExportSources(streamId int64, from, to time.Time, workDir string) ([]*exportSource, error) {
+	sources := make([]*exportSource, 0)
+
+	// Archived videos are copied into the work directory
+	for _, date := range common.GetDatesBetween(from, to) {
+		recordKey := m.getRecordKey(streamId, date)
+		backend, err := m.getBackend(streamId, date)
+		if err != nil {
+			return nil, err
+		}
+		playlist, err := m.readArchivedPlaylist(backend, recordKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := fillSegmentTimes(playlist, date); err != nil {
+			return nil, err
+		}
+		for _, seg := range playlist.Segments {
+			src := &exportSource{start: seg.Time, duration: seg.Duration}
+			if !src.end().After(from) || !src.start.Before(to) {
+				continue
+			}
+			src.path = filepath.Join(workDir, date+"-"+filepath.Base(seg.URI))
+			if err := copyArchivedVideo(backend, storage.Key(recordKey, filepath.Base(seg.URI)), src.path); err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		}
+	}
+
+	// Live videos are linked so that they are not lost while being archived
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(streamId, 10))
+	liveFiles, err := common.ReadVideoFilesInTimeRange(liveDir, from, to.Add(time.Minute), common.VideoFileExt)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	segmentMaps := make(map[string]map[string]*common.Segment)
+	for _, f := range liveFiles {
+		date := f.ModTime().In(common.Loc).Format(common.DateFormat)
+		if _, ok := segmentMaps[date]; !ok {
+			segmentMaps[date] = m.getLiveSegmentMap(streamId, date)
+		}
+		start := getLiveVideoStartTime(f, segmentMaps[date])
+		src := &exportSource{start: start, duration: f.ModTime().Sub(start).Seconds()}
+		if !src.end().After(from) || !src.start.Before(to) {
+			continue
+		}
+		src.path = filepath.Join(workDir, "live-"+f.Name())
+		if err := linkOrCopyFile(filepath.Join(liveDir, f.Name()), src.path); err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].start.Before(sources[j].start)
+	})
+
+	// Videos archived while collecting can be found twice
+	list := make([]*exportSource, 0, len(sources))
+	var last time.Time
+	for _, src := range sources {
+		if !src.end().After(last.Add(500 * time.Millisecond)) {
+			continue
+		}
+		list = append(list, src)
+		last = src.end()
+	}
+	return list, nil
+}
+
+// getExportCut returns the offset and the duration to cut from the concatenated sources.
+// Gaps between sources don't exist in the concatenated video, so the duration is the sum of the covered parts of sources.
+func getExportCut(sources []*exportSource, from, to time.Time) (float64, float64) {
+	offset := from.Sub(sources[0].start).Seconds()
+	if offset < 0 {
+		offset = 0
+	}
+	var duration float64
+	for _, src := range sources {
+		start, end := src.start, src.end()
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			duration += end.Sub(start).Seconds()
+		}
+	}
+	return offset, duration
+}
+
+func copyArchivedVideo(backend storage.Backend, key, path string) error {
+	object, err := backend.Get(key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, object)
+	return err
+}
+
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func writeExportListToText(path string, sources []*exportSource) error {
+	var text string
+	for _, src := range sources {
+		p, _ := filepath.Abs(src.path)
+		text += fmt.Sprintf("file '%s'\n", filepath.ToSlash(p))
+	}
+	return ioutil.WriteFile(path, []byte(text), 0644)
+}
+
+func (m *Manager) getExportFilePath(job *common.Job) string {
+	ext := ".mp4"
+	if job.Format == common.ExportBundle {
+		ext = ".zip"
+	}
+	return filepath.Join(m.server.config.Export.Dir, fmt.Sprintf("export-%d%s", job.Id, ext))
+}
+
+// getExportedFile returns the path of the exported file which hasn't expired
+func (m *Manager) getExportedFile(jobId int64) (*common.Job, string, error) {
+	job, err := m.jobs.get(jobId)
+	if err != nil {
+		return nil, "", err
+	}
+	if job.Type != common.JobExport {
+		return nil, "", common.ErrorJobNotFound
+	}
+	if job.Status != common.JobDone || job.Result == nil {
+		return nil, "", common.ErrorInvalidJobStatus
+	}
+	if job.Result.Expires < time.Now().Unix() {
+		return nil, "", common.ErrorExpired
+	}
+	return job, m.getExportFilePath(job), nil
+}
+
+func (m *Manager) deleteExpiredExports() error {
+	jobs, err := m.jobs.list(func(job *common.Job) bool {
+		return job.Type == common.JobExport && job.Result != nil && len(job.Result.File) > 0
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, job := range jobs {
+		if job.Result.Expires >= now {
+			continue
+		}
+		path := m.getExportFilePath(job)
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				log.Error(err)
+			}
+			continue
+		}
+		log.WithFields(log.Fields{
+			"jobId": job.Id,
+			"file":  path,
+		}).Debug("[manager] exported file has expired")
+	}
+	return nil
+}
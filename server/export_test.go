@@ -0,0 +1,46 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGetExportCut(t *testing.T) {
+	base := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	newSources := func(starts ...int) []*exportSource {
+		sources := make([]*exportSource, 0)
+		for _, s := range starts {
+			sources = append(sources, &exportSource{start: base.Add(time.Duration(s) * time.Second), duration: 10})
+		}
+		return sources
+	}
+
+	tests := []struct {
+		name     string
+		sources  []*exportSource
+		from, to int
+		offset   float64
+		duration float64
+	}{
+		{"continuous", newSources(0, 10, 20), 5, 25, 5, 20},
+		{"gap of 100 seconds", newSources(0, 10, 120, 130), 5, 135, 5, 30},
+		{"starts in the gap", newSources(0, 120, 130), 50, 135, 0, 15},
+		{"ends in the gap", newSources(0, 10, 120), 5, 60, 5, 15},
+		{"range beyond sources", newSources(0, 10), -10, 100, 0, 20},
+	}
+	for _, tt := range tests {
+		from := base.Add(time.Duration(tt.from) * time.Second)
+		to := base.Add(time.Duration(tt.to) * time.Second)
+		sources := make([]*exportSource, 0)
+		for _, src := range tt.sources {
+			if src.end().After(from) && src.start.Before(to) {
+				sources = append(sources, src)
+			}
+		}
+		offset, duration := getExportCut(sources, from, to)
+		if math.Abs(offset-tt.offset) > 0.001 || math.Abs(duration-tt.duration) > 0.001 {
+			t.Errorf("%s: expected offset=%.1f duration=%.1f, got offset=%.1f duration=%.1f", tt.name, tt.offset, tt.duration, offset, duration)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
+	"github.com/devplayg/rtsp-stream/streaming"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// keyframeIndex has keyframes of archived segments of the day by file name
+type keyframeIndex map[string][]*common.Keyframe
+
+func (m *Manager) readKeyframeIndex(backend storage.Backend, recordKey string) (keyframeIndex, error) {
+	index := make(keyframeIndex)
+	object, err := backend.Get(storage.Key(recordKey, common.KeyframeFileName))
+	if err == storage.ErrNotFound {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	if err := json.NewDecoder(object).Decode(&index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// writeKeyframeIndex adds keyframes of the segments in the directory to the index of the day,
+// removes the deleted segments and writes the index into the directory to be published
+func (m *Manager) writeKeyframeIndex(backend storage.Backend, recordKey, dir string, segments []*streaming.PlaylistSegment, deleted []string) error {
+	index, err := m.readKeyframeIndex(backend, recordKey)
+	if err != nil {
+		return err
+	}
+	for _, name := range deleted {
+		delete(index, name)
+	}
+	for _, seg := range segments {
+		name := filepath.Base(seg.URI)
+		keyframes, err := streaming.GetKeyframesFromFile(filepath.Join(dir, name), seg.Duration)
+		if err != nil {
+			return err
+		}
+		index[name] = keyframes
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, common.KeyframeFileName), data, 0644)
+}
+
+// getIframePlaylist returns the I-frame only playlist of the day.
+// Keyframes of archived segments come from the index of the day, and those of live videos from their records.
+func (m *Manager) getIframePlaylist(streamId int64, date string) (*streaming.Playlist, error) {
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+	id := strconv.FormatInt(streamId, 10)
+	segments := make([]*streaming.PlaylistSegment, 0)
+
+	// Segments deleted by retention or quota remain in the index until the day is deleted
+	recordKey := m.getRecordKey(streamId, date)
+	backend, err := m.getBackend(streamId, date)
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := m.readArchivedPlaylist(backend, recordKey)
+	if err != nil {
+		return nil, err
+	}
+	index, err := m.readKeyframeIndex(backend, recordKey)
+	if err != nil {
+		return nil, err
+	}
+	var archivedUntil time.Time
+	for _, seg := range playlist.Segments {
+		name := path.Base(seg.URI)
+		segments = append(segments, getIframeSegments(path.Join("/videos", id, "date", date, name), seg.Time, index[name])...)
+		archivedUntil = seg.Time.Add(time.Duration(seg.Duration * float64(time.Second)))
+	}
+
+	// Live videos which haven't been archived yet
+	records, err := stream.GetSegments(date)
+	if err != nil {
+		return nil, err
+	}
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, id)
+	live := 0
+	for _, r := range records {
+		if len(r.Keyframes) < 1 {
+			continue
+		}
+		f, err := os.Stat(filepath.Join(liveDir, r.URI))
+		if err != nil {
+			continue
+		}
+		start := getLiveVideoStartTime(f, map[string]*common.Segment{r.URI: r})
+		if start.Before(archivedUntil) {
+			// Archived while collecting
+			continue
+		}
+		segments = append(segments, getIframeSegments(path.Join("/videos", id, "today", r.URI), start, r.Keyframes)...)
+		live++
+	}
+	if len(segments) < 1 {
+		return nil, common.ErrorNoVideos
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Time.Before(segments[j].Time)
+	})
+	iframes := streaming.NewPlaylist(streaming.PlaylistEvent)
+	iframes.IframesOnly = true
+	iframes.Segments = segments
+	if playlist.Ended && live == 0 {
+		iframes.Type = streaming.PlaylistVod
+		iframes.Ended = true
+	}
+	return iframes, nil
+}
+
+func getIframeSegments(uri string, start time.Time, keyframes []*common.Keyframe) []*streaming.PlaylistSegment {
+	segments := make([]*streaming.PlaylistSegment, 0, len(keyframes))
+	for _, k := range keyframes {
+		if k.Length < 1 {
+			continue
+		}
+		segments = append(segments, &streaming.PlaylistSegment{
+			URI:      uri,
+			Duration: k.Duration,
+			Time:     start.Add(time.Duration(k.Time * float64(time.Second))),
+			Length:   k.Length,
+			Offset:   k.Offset,
+		})
+	}
+	return segments
+}
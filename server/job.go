@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	jobPollInterval = 5 * time.Second
+	jobRetryDelay   = 1 * time.Minute // multiplied by attempts
+)
+
+type jobHandler func(ctx context.Context, job *common.Job) error
+
+// jobQueue runs jobs persisted in the database with a bounded worker pool.
+// Jobs with the same key run one at a time in order of their id.
+type jobQueue struct {
+	workers  int
+	handlers map[string]jobHandler
+	notify   chan struct{}
+	ctx      context.Context
+	wg       sync.WaitGroup
+	running  map[int64]context.CancelFunc
+	canceled map[int64]bool // canceled by user while running
+	sync.Mutex
+}
+
+func newJobQueue(ctx context.Context, workers int) *jobQueue {
+	return &jobQueue{
+		workers:  workers,
+		handlers: make(map[string]jobHandler),
+		notify:   make(chan struct{}, 1),
+		ctx:      ctx,
+		running:  make(map[int64]context.CancelFunc),
+		canceled: make(map[int64]bool),
+	}
+}
+
+func (q *jobQueue) register(jobType string, handler jobHandler) {
+	q.handlers[jobType] = handler
+}
+
+// start resumes jobs which were running when the server stopped and starts workers
+func (q *jobQueue) start() error {
+	resumed := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(common.JobBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var job common.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if job.Status != common.JobRunning {
+				return nil
+			}
+			job.Status = common.JobQueued
+			resumed++
+			return putJob(b, &job)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	log.WithFields(log.Fields{
+		"workers": q.workers,
+		"resumed": resumed,
+	}).Debug("[job] job queue has been started")
+	return nil
+}
+
+// wait waits for workers to finish after the context is canceled
+func (q *jobQueue) wait() {
+	q.wg.Wait()
+}
+
+func (q *jobQueue) add(jobs ...*common.Job) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(common.JobBucket)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			job.Id = int64(id)
+			if err := putJob(b, job); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	q.wakeUp()
+	return nil
+}
+
+func (q *jobQueue) wakeUp() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *jobQueue) work() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			job, err := q.claim()
+			if err != nil {
+				log.Error(err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(job)
+			if q.ctx.Err() != nil {
+				return
+			}
+		}
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the next runnable job as running
+func (q *jobQueue) claim() (*common.Job, error) {
+	if q.ctx.Err() != nil {
+		return nil, nil
+	}
+	var claimed *common.Job
+	now := time.Now().Unix()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return nil
+		}
+		blocked := make(map[string]bool)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var job common.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if job.IsFinished() {
+				continue
+			}
+			key := job.Key()
+			if blocked[key] {
+				continue
+			}
+			blocked[key] = true
+			if job.Status != common.JobQueued || job.NotBefore > now {
+				continue
+			}
+			job.Status = common.JobRunning
+			job.Started = now
+			job.Attempts++
+			claimed = &job
+			return putJob(b, &job)
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+func (q *jobQueue) run(job *common.Job) {
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		job.Status = common.JobFailed
+		job.Error = "unknown job type: " + job.Type
+		job.Finished = time.Now().Unix()
+		if err := q.update(job); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	t := time.Now()
+	log.WithFields(log.Fields{
+		"id":       job.Id,
+		"type":     job.Type,
+		"streamId": job.StreamId,
+		"attempts": job.Attempts,
+	}).Debug("[job] job has been started")
+
+	ctx, cancel := context.WithCancel(q.ctx)
+	q.Lock()
+	q.running[job.Id] = cancel
+	if q.canceled[job.Id] {
+		cancel()
+	}
+	q.Unlock()
+
+	err := handler(ctx, job)
+
+	q.Lock()
+	canceled := q.canceled[job.Id]
+	delete(q.running, job.Id)
+	delete(q.canceled, job.Id)
+	q.Unlock()
+	cancel()
+
+	job.Finished = time.Now().Unix()
+	job.Duration = time.Since(t).Seconds()
+	switch {
+	case err == nil:
+		job.Status = common.JobDone
+		job.Error = ""
+	case canceled:
+		job.Status = common.JobCanceled
+		job.Error = err.Error()
+	case q.ctx.Err() != nil:
+		// Shutting down; the job will be resumed after restart
+		job.Status = common.JobQueued
+		job.Attempts--
+	case job.Attempts < job.MaxAttempts:
+		job.Status = common.JobQueued
+		job.Error = err.Error()
+		job.NotBefore = time.Now().Add(time.Duration(job.Attempts) * jobRetryDelay).Unix()
+	default:
+		job.Status = common.JobFailed
+		job.Error = err.Error()
+	}
+	if err := q.update(job); err != nil {
+		log.Error(err)
+	}
+
+	log.WithFields(log.Fields{
+		"id":            job.Id,
+		"type":          job.Type,
+		"streamId":      job.StreamId,
+		"status":        job.Status,
+		"error":         job.Error,
+		"duration(sec)": job.Duration,
+	}).Debug("[job] job has been finished")
+}
+
+// cancel cancels the queued job or stops the running job
+func (q *jobQueue) cancel(id int64) (*common.Job, error) {
+	var job *common.Job
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return common.ErrorJobNotFound
+		}
+		v := b.Get(common.Int64ToBytes(id))
+		if v == nil {
+			return common.ErrorJobNotFound
+		}
+		if err := json.Unmarshal(v, &job); err != nil {
+			return err
+		}
+		switch job.Status {
+		case common.JobQueued:
+			job.Status = common.JobCanceled
+			job.Finished = time.Now().Unix()
+			return putJob(b, job)
+		case common.JobRunning:
+			q.Lock()
+			q.canceled[id] = true
+			if cancel, ok := q.running[id]; ok {
+				cancel()
+			}
+			q.Unlock()
+			return nil
+		}
+		return common.ErrorInvalidJobStatus
+	})
+	return job, err
+}
+
+// retry queues the failed or canceled job again
+func (q *jobQueue) retry(id int64) (*common.Job, error) {
+	var job *common.Job
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return common.ErrorJobNotFound
+		}
+		v := b.Get(common.Int64ToBytes(id))
+		if v == nil {
+			return common.ErrorJobNotFound
+		}
+		if err := json.Unmarshal(v, &job); err != nil {
+			return err
+		}
+		if job.Status != common.JobFailed && job.Status != common.JobCanceled {
+			return common.ErrorInvalidJobStatus
+		}
+		job.Status = common.JobQueued
+		job.Attempts = 0
+		job.Error = ""
+		job.NotBefore = 0
+		job.Result = nil
+		return putJob(b, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.wakeUp()
+	return job, nil
+}
+
+// update saves the job; handlers use it to save checkpoints
+func (q *jobQueue) update(job *common.Job) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return common.ErrorJobNotFound
+		}
+		return putJob(b, job)
+	})
+}
+
+func (q *jobQueue) get(id int64) (*common.Job, error) {
+	var job *common.Job
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return common.ErrorJobNotFound
+		}
+		v := b.Get(common.Int64ToBytes(id))
+		if v == nil {
+			return common.ErrorJobNotFound
+		}
+		return json.Unmarshal(v, &job)
+	})
+	return job, err
+}
+
+func (q *jobQueue) list(filter func(job *common.Job) bool) ([]*common.Job, error) {
+	jobs := make([]*common.Job, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var job common.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if filter == nil || filter(&job) {
+				jobs = append(jobs, &job)
+			}
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+// prune deletes jobs finished before the time
+func (q *jobQueue) prune(t time.Time) (int, error) {
+	count := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.JobBucket)
+		if b == nil {
+			return nil
+		}
+		keys := make([][]byte, 0)
+		err := b.ForEach(func(k, v []byte) error {
+			var job common.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if job.IsFinished() && job.Finished < t.Unix() {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	return count, err
+}
+
+func putJob(b *bolt.Bucket, job *common.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.Put(common.Int64ToBytes(job.Id), data)
+}
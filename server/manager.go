@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,7 +31,11 @@ type Manager struct {
 	ctx                  context.Context
 	cancel               context.CancelFunc
 	watcherCheckInterval time.Duration
-	onArchiving          bool
+	jobs                 *jobQueue // Archive and export jobs
+	keyOnce              sync.Once
+	signingKey           ed25519.PrivateKey // Signs evidence bundles
+	keyErr               error
+	watchdog             diskWatchdog
 	sync.RWMutex
 }
 
@@ -54,6 +59,15 @@ func (m *Manager) init() error {
 		return err
 	}
 
+	m.jobs = newJobQueue(m.ctx, m.server.config.Archive.Workers)
+	m.jobs.register(common.JobArchive, m.runArchiveJob)
+	m.jobs.register(common.JobExport, m.runExportJob)
+	m.jobs.register(common.JobMove, m.runMoveJob)
+	m.jobs.register(common.JobTranscode, m.runTranscodeJob)
+	if err := m.jobs.start(); err != nil {
+		return err
+	}
+
 	//if err := m.cleanStreamMetaFile(); err != nil {
 	//	return err
 	//}
@@ -62,7 +76,7 @@ func (m *Manager) init() error {
 		return err
 	}
 
-	if err := m.deleteOldData(m.server.config.DataRetentionDays); err != nil {
+	if err := m.deleteOldData(); err != nil {
 		log.Error(err)
 	}
 
@@ -82,76 +96,6 @@ func (m *Manager) init() error {
 	return nil
 }
 
-func (m *Manager) getLastArchivingDate(t time.Time) (string, error) {
-	val, err := GetValueFromDbBucket(common.ConfigBucket, common.LastArchivingDateKey)
-	if err != nil {
-		return "", err
-	}
-	if val == nil {
-		return t.Add(7 * -24 * time.Hour).Format(common.DateFormat), nil
-	}
-	return string(val), nil
-}
-
-func (m *Manager) checkOldLiveVideoFiles() error {
-	t := time.Now().In(common.Loc)
-	lastArchivingDate, err := m.getLastArchivingDate(t)
-	if err != nil {
-		return err
-	}
-	expectedDate := t.Add(-24 * time.Hour).Format(common.DateFormat) // yesterday
-	log.WithFields(log.Fields{
-		"last":     lastArchivingDate,
-		"expected": expectedDate,
-	}).Debug("[manager] checking last archiving date")
-
-	if lastArchivingDate == expectedDate {
-		PutDataIntoDbBucket(common.ConfigBucket, common.LastArchivingDateKey, []byte(expectedDate))
-		return nil
-	}
-
-	from, err := time.ParseInLocation(common.DateFormat, lastArchivingDate, common.Loc)
-	if err != nil {
-		return err
-	}
-	to, err := time.ParseInLocation(common.DateFormat, expectedDate, common.Loc)
-	if err != nil {
-		return err
-	}
-	if from.After(to) {
-		return errors.New("invalid system time on scheduler")
-	}
-
-	d := from
-	for d.Before(to) || d.Equal(to) {
-		log.WithFields(log.Fields{
-			"targetDate": d.Format(common.DateFormat),
-		}).Debug("[manager] handling missed archiving task")
-		if err := m.startToArchiveVideos(d.Format(common.DateFormat)); err != nil {
-			log.Error(err)
-		}
-		d = d.Add(24 * time.Hour)
-	}
-
-	PutDataIntoDbBucket(common.ConfigBucket, common.LastArchivingDateKey, []byte(expectedDate))
-
-	//err := common.DB.View(func(tx *bolt.Tx) error {
-	//    b := tx.Bucket(common.ConfigBucket)
-	//    value := b.Get(common.LastArchivingDateKey)
-	//    if value == nil {
-	//        lastArchivingDate = t.Add(7 * -24*time.Hour).Format(common.DateFormat)
-	//        return nil
-	//    }
-	//    lastArchivingDate = string(value)
-	//    return nil
-	//})
-	//if err != nil {
-	//    return err
-	//}
-
-	return nil
-}
-
 func (m *Manager) initStreamDatabases() error {
 	for id, _ := range m.streams {
 		db, err := m.openStreamDB(id)
@@ -169,6 +113,7 @@ func (m *Manager) start() error {
 	}
 
 	go m.startStreamWatcher()
+	go m.startDiskWatchdog()
 
 	return nil
 }
@@ -226,7 +171,6 @@ func (m *Manager) loadStreamsFromDatabase() error {
 
 	//return err
 
-	
 	// fetch and unmarshal
 	// lock
 	// assign
@@ -269,11 +213,11 @@ func (m *Manager) getSimpleStreams() []*streaming.SimpleStream {
 
 func (m *Manager) getStreamById(id int64) *streaming.Stream {
 	m.Lock()
-	if _, ok := m.streams[id]; !ok {
+	stream, ok := m.streams[id]
+	m.Unlock()
+	if !ok {
 		return nil
 	}
-	stream := m.streams[id]
-	m.Unlock()
 
 	if stream.Cmd != nil && stream.Cmd.Process != nil {
 		stream.Pid = stream.Cmd.Process.Pid
@@ -282,10 +226,24 @@ func (m *Manager) getStreamById(id int64) *streaming.Stream {
 	return stream
 }
 
+// getStreamGroup returns the group of the stream; empty if the stream doesn't exist
+func (m *Manager) getStreamGroup(id int64) string {
+	m.RLock()
+	defer m.RUnlock()
+	stream, ok := m.streams[id]
+	if !ok {
+		return ""
+	}
+	return stream.Group
+}
+
 func (m *Manager) addStream(stream *streaming.Stream) error {
 	if err := m.isValidStreamUri(stream); err != nil {
 		return err
 	}
+	if err := isValidTranscodePolicy(stream.Transcode); err != nil {
+		return err
+	}
 
 	if err := m.issueStream(stream); err != nil {
 		return err
@@ -368,6 +326,9 @@ func (m *Manager) updateStream(input *streaming.Stream) error {
 	if err := m.isValidStreamUri(input); err != nil {
 		return err
 	}
+	if err := isValidTranscodePolicy(input.Transcode); err != nil {
+		return err
+	}
 
 	stream := m.getStreamById(input.Id)
 	if stream == nil {
@@ -397,6 +358,14 @@ func (m *Manager) _updateStream(stream, input *streaming.Stream) (bool, error) {
 		needToReload = true
 	}
 
+	if stream.Enabled != input.Enabled {
+		reason := common.ReasonDisabled
+		if input.Enabled {
+			reason = common.ReasonEnabled
+		}
+		stream.RecordEvent(stream.Status, reason)
+	}
+
 	m.RLock()
 	defer m.RUnlock()
 	stream.Name = input.Name
@@ -407,6 +376,10 @@ func (m *Manager) _updateStream(stream, input *streaming.Stream) (bool, error) {
 	stream.Password = input.Password
 	stream.ProtocolInfo = input.ProtocolInfo
 	stream.UriHash = input.UriHash
+	stream.DataRetentionHours = input.DataRetentionHours
+	stream.QuotaBytes = input.QuotaBytes
+	stream.Group = input.Group
+	stream.Transcode = input.Transcode
 	stream.Updated = time.Now().Unix()
 	return needToReload, m.saveStream(stream)
 }
@@ -586,13 +559,27 @@ func (m *Manager) stopStreaming(id int64, from string) error {
 		return errors.New(fmt.Sprintf("[manager] stream-%d is already starting now", id))
 	}
 	stream.Status = common.Stopping
+	stream.SetStopReason(getStopReason(from))
 	stream.Stop()
 
 	return nil
 }
 
+func getStopReason(from string) string {
+	switch from {
+	case "manager":
+		return common.ReasonSchedule
+	case "watcher":
+		return common.ReasonStalled
+	}
+	return common.ReasonStopped
+}
+
 func (m *Manager) Stop() error {
 	m.cancel()
+	if m.jobs != nil {
+		m.jobs.wait()
+	}
 	for id, _ := range m.streams {
 		if err := m.stopStreaming(id, "manager"); err != nil {
 			log.Error(err)
@@ -661,16 +648,6 @@ func (m *Manager) startStreamWatcher() {
 	}
 }
 
-func (m *Manager) getM3u8(id int64, date string) (string, error) { 
-	stream := m.getStreamById(id)
-	if stream == nil {
-		return "", common.ErrorStreamNotFound
-	}
-
-	tags, err := stream.GetM3u8Tags(date)
-	return tags, err
-}
-
 func (m *Manager) openStreamDB(id int64) (*bolt.DB, error) {
 	path := filepath.Join(m.server.dbDir, "stream-"+strconv.FormatInt(id, 10)+".db")
 	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
@@ -807,8 +784,14 @@ func (m *Manager) getVideoRecords() ([]map[string]interface{}, error) {
 //	return bucketNames
 //}
 
-func (m *Manager) getLiveData() map[string]interface{} {
-	streams := m.getStreams()
+// getLiveData returns data of the live page with the streams which can be viewed
+func (m *Manager) getLiveData(canView func(id int64) bool) map[string]interface{} {
+	streams := make([]*streaming.Stream, 0)
+	for _, s := range m.getStreams() {
+		if canView(s.Id) {
+			streams = append(streams, s)
+		}
+	}
 
 	return map[string]interface{}{
 		"streams": streams,
@@ -3,9 +3,12 @@ package server
 import (
 	"fmt"
 	"github.com/boltdb/bolt"
+	"github.com/devplayg/hippo"
 	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
 	"github.com/devplayg/rtsp-stream/streaming"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,63 +16,234 @@ import (
 	"time"
 )
 
-func (m *Manager) deleteOldData(dataRetentionDays int) error {
-	if dataRetentionDays < 0 {
-		return nil
+// deleteOldData deletes archived videos, live videos, records and annotations of streams older than their retention
+func (m *Manager) deleteOldData() error {
+	busy, err := m.getBusyDays()
+	if err != nil {
+		return err
+	}
+	for _, s := range m.getStreams() {
+		t := time.Now().In(common.Loc).Add(-m.getRetention(s))
+		log.WithFields(log.Fields{
+			"streamId":   s.Id,
+			"targetTime": t.Format(time.RFC3339),
+		}).Debug("[manager] target time to delete")
+		if err := m.deleteOldDataOfStream(s, t, busy); err != nil {
+			log.Error(err)
+		}
+		if err := m.deleteOldLiveData(s, t); err != nil {
+			log.Error(err)
+		}
+		if err := m.deleteOldAnnotations(s, t); err != nil {
+			log.Error(err)
+		}
 	}
+	return nil
+}
 
-	t := time.Now().In(common.Loc)
-	targetTime := t.Add(time.Duration(dataRetentionDays*-24) * time.Hour)
-	targetDate := targetTime.Format(common.DateFormat)
-	log.WithFields(log.Fields{
-		"now":               t.Format(time.RFC3339),
-		"targetDate":        targetDate,
-		"dataRetentionDays": dataRetentionDays,
-	}).Debug("[manager] target date to delete")
+// getRetention returns the retention of the stream; the global retention applies if it's not set
+func (m *Manager) getRetention(s *streaming.Stream) time.Duration {
+	if s.DataRetentionHours > 0 {
+		return time.Duration(s.DataRetentionHours) * time.Hour
+	}
+	return time.Duration(m.server.config.DataRetentionDays) * 24 * time.Hour
+}
 
-	for _, s := range m.streams {
-		err := m.deleteOldDataOfStream(s, targetDate)
+// deleteOldDataOfStream deletes archived days which ended before the time and
+// segments before the time from the day in progress
+func (m *Manager) deleteOldDataOfStream(s *streaming.Stream, t time.Time, busy map[string]bool) error {
+	days, err := m.getArchivedDays(s)
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		if d.protected {
+			continue
+		}
+		date := d.date
+		start, err := time.ParseInLocation(common.DateFormat, date, common.Loc)
 		if err != nil {
+			continue
+		}
+		if !start.Before(t) {
+			break
+		}
+		if busy[m.getRecordKey(s.Id, date)] {
+			continue
+		}
+		if start.AddDate(0, 0, 1).After(t) {
+			if err := m.deleteArchivedSegmentsBefore(s, date, t); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"streamId":  s.Id,
+			"key":       date,
+			"recordKey": m.getRecordKey(s.Id, date),
+		}).Debug("data is expired")
+		if err := m.deleteArchivedDay(s, date); err != nil {
 			log.Error(err)
 		}
 	}
+	return nil
+}
 
+func (m *Manager) deleteArchivedSegmentsBefore(s *streaming.Stream, date string, t time.Time) error {
+	backend, err := m.getBackend(s.Id, date)
+	if err != nil {
+		return err
+	}
+	playlist, err := m.readArchivedPlaylist(backend, m.getRecordKey(s.Id, date))
+	if err != nil {
+		return err
+	}
+	n := 0
+	for n < len(playlist.Segments) {
+		seg := playlist.Segments[n]
+		if seg.Time.IsZero() || seg.Time.Add(time.Duration(seg.Duration*float64(time.Second))).After(t) {
+			break
+		}
+		n++
+	}
+	if n < 1 {
+		return nil
+	}
+	if n == len(playlist.Segments) {
+		return m.deleteArchivedDay(s, date)
+	}
+	if err := m.deleteLeadingSegments(s, date, playlist, n); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"streamId": s.Id,
+		"date":     date,
+		"segments": n,
+	}).Debug("[manager] expired segments have been deleted")
 	return nil
 }
 
-func (m *Manager) deleteOldDataOfStream(s *streaming.Stream, date string) error {
-	bucketName := []byte(fmt.Sprintf("%s%d", common.VideoBucketPrefix, s.Id))
-	return db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-		if b == nil {
-			return nil
+// deleteLeadingSegments deletes the first n segments from the archived playlist of the day
+func (m *Manager) deleteLeadingSegments(s *streaming.Stream, date string, playlist *streaming.Playlist, n int) error {
+	recordKey := m.getRecordKey(s.Id, date)
+	backend, err := m.getBackend(s.Id, date)
+	if err != nil {
+		return err
+	}
+	deleted := playlist.Segments[:n]
+	playlist.Segments = playlist.Segments[n:]
+	playlist.Segments[0].Discontinuity = false
+
+	// The playlist is updated first so that players never refer to deleted segments
+	dir := m.getStagingDir(s.Id, date)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := hippo.EnsureDir(dir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := writePlaylist(filepath.Join(dir, common.LiveM3u8FileName), playlist); err != nil {
+		return err
+	}
+	if err := m.writeArchiveManifest(s.Id, date, dir, []string{common.LiveM3u8FileName}); err != nil {
+		return err
+	}
+	if err := m.publishArchivedVideos(backend, recordKey, dir, []string{common.LiveM3u8FileName}); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(deleted))
+	for _, seg := range deleted {
+		name := filepath.Base(seg.URI)
+		if err := backend.Delete(storage.Key(recordKey, name)); err != nil && err != storage.ErrNotFound {
+			return err
 		}
-		return b.ForEach(func(k, v []byte) error {
-			result := strings.Compare(date, string(k))
-			if result != 1 {
-				return nil
-			}
-			dir := filepath.Join(m.server.config.Storage.RecordDir, m.server.config.Storage.Bucket, strconv.FormatInt(s.Id, 10), string(k))
-			log.WithFields(log.Fields{
-				"streamId": s.Id,
-				"key":      string(k),
-				"dir":      dir,
-			}).Debug("data is expired")
-			var err error
-
-			err = os.RemoveAll(dir)
-			if err != nil {
-				log.Error(err)
-			}
+		names = append(names, name)
+	}
+	if err := m.deleteArchiveManifestEntries(s, date, names); err != nil {
+		return err
+	}
 
-			err = b.Delete(k)
-			if err != nil {
-				log.Error(err)
-			}
+	size, err := storage.GetSize(backend, recordKey+"/")
+	if err != nil {
+		return err
+	}
+	return m.writeVideoArchivingHistory(s.Id, date, size)
+}
+
+// deleteOldLiveData deletes leftover live videos and records of the stream database older than the time
+func (m *Manager) deleteOldLiveData(s *streaming.Stream, t time.Time) error {
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(s.Id, 10))
+	files, err := ioutil.ReadDir(liveDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	count := 0
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !strings.HasSuffix(f.Name(), common.VideoFileExt) || !f.ModTime().Before(t) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(liveDir, f.Name())); err != nil {
+			log.Error(err)
+			continue
+		}
+		count++
+	}
+
+	if s.DB == nil {
+		return nil
+	}
+	dates, err := s.DeleteDataBefore(t)
+	if err != nil {
+		return err
+	}
+	for _, date := range dates {
+		if err := m.deleteChain(s, date); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if count > 0 || len(dates) > 0 {
+		log.WithFields(log.Fields{
+			"streamId": s.Id,
+			"files":    count,
+			"dates":    dates,
+		}).Debug("[manager] old live data has been deleted")
+	}
+	return nil
+}
+
+// deleteArchivedDay deletes archived videos of the date and their records
+func (m *Manager) deleteArchivedDay(s *streaming.Stream, date string) error {
+	backend, err := m.getBackend(s.Id, date)
+	if err != nil {
+		return err
+	}
+	if _, err := storage.DeletePrefix(backend, m.getRecordKey(s.Id, date)+"/"); err != nil {
+		return err
+	}
 
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(fmt.Sprintf("%s%d", common.VideoBucketPrefix, s.Id)))
+		if b == nil {
 			return nil
-		})
+		}
+		return b.Delete([]byte(date))
 	})
+	if err != nil {
+		log.Error(err)
+	}
+
+	if err := m.deleteArchiveManifest(s, date); err != nil {
+		log.Error(err)
+	}
+
+	if err := m.deleteChain(s, date); err != nil {
+		log.Error(err)
+	}
+	return nil
 }
 
 //func DeleteVideoRecordsBefore(dir, date string) error {
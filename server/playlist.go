@@ -0,0 +1,153 @@
+package server
+
+import (
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Playlists longer than this are not allowed
+const maxPlaylistDuration = 7 * 24 * time.Hour
+
+// rangeSegment is a segment of the time range playlist
+type rangeSegment struct {
+	*streaming.PlaylistSegment
+	source string // date of archived segments or "live"
+}
+
+func (s *rangeSegment) end() time.Time {
+	return s.Time.Add(time.Duration(s.Duration * float64(time.Second)))
+}
+
+// getRangePlaylist stitches archived and live videos in the time range into a playlist.
+// It's a VOD playlist if the range has been closed, otherwise an EVENT playlist which grows.
+func (m *Manager) getRangePlaylist(streamId int64, from, to time.Time) (*streaming.Playlist, error) {
+	if !from.Before(to) || to.Sub(from) > maxPlaylistDuration {
+		return nil, common.ErrorInvalidTime
+	}
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+	id := strconv.FormatInt(streamId, 10)
+	dates := common.GetDatesBetween(from, to)
+
+	segments := make([]*rangeSegment, 0)
+	inRange := func(seg *rangeSegment) bool {
+		return seg.end().After(from) && seg.Time.Before(to)
+	}
+
+	// Archived videos from the tier which holds the day
+	for _, date := range dates {
+		backend, err := m.getBackend(streamId, date)
+		if err != nil {
+			return nil, err
+		}
+		playlist, err := m.readArchivedPlaylist(backend, m.getRecordKey(streamId, date))
+		if err != nil {
+			return nil, err
+		}
+		// Days archived before segments had EXT-X-PROGRAM-DATE-TIME
+		if err := fillSegmentTimes(playlist, date); err != nil {
+			return nil, err
+		}
+		for _, s := range playlist.Segments {
+			seg := &rangeSegment{
+				PlaylistSegment: &streaming.PlaylistSegment{
+					URI:      path.Join("/videos", id, "date", date, path.Base(s.URI)),
+					Duration: s.Duration,
+					Time:     s.Time.In(common.Loc),
+				},
+				source: date,
+			}
+			if inRange(seg) {
+				segments = append(segments, seg)
+			}
+		}
+	}
+
+	// Indexed live videos which haven't been archived yet
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, id)
+	files, err := ioutil.ReadDir(liveDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	liveFiles := make(map[string]os.FileInfo)
+	for _, f := range files {
+		liveFiles[f.Name()] = f
+	}
+	for _, date := range dates {
+		list, err := stream.GetSegments(date)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range list {
+			f, ok := liveFiles[s.URI]
+			if !ok {
+				continue
+			}
+			seg := &rangeSegment{
+				PlaylistSegment: &streaming.PlaylistSegment{
+					URI:      path.Join("/videos", id, "today", s.URI),
+					Duration: s.Duration,
+					Time:     getLiveVideoStartTime(f, map[string]*common.Segment{s.URI: s}),
+				},
+				source: "live",
+			}
+			if inRange(seg) {
+				segments = append(segments, seg)
+			}
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Time.Before(segments[j].Time)
+	})
+
+	playlist := streaming.NewPlaylist(streaming.PlaylistEvent)
+	if !to.After(time.Now()) {
+		playlist.Type = streaming.PlaylistVod
+		playlist.Ended = true
+	}
+
+	// Videos archived while collecting can be found twice
+	var last *rangeSegment
+	for _, seg := range segments {
+		if last != nil && !seg.end().After(last.end().Add(500*time.Millisecond)) {
+			continue
+		}
+		if last != nil {
+			tolerance := math.Max(minTimelineTolerance, seg.Duration*1.5)
+			seg.Discontinuity = seg.source != last.source || seg.Time.Sub(last.end()).Seconds() > tolerance
+		}
+		playlist.Segments = append(playlist.Segments, seg.PlaylistSegment)
+		last = seg
+	}
+	if playlist.Ended && len(playlist.Segments) < 1 {
+		return nil, common.ErrorNoVideos
+	}
+	return playlist, nil
+}
+
+// fillSegmentTimes sets times of archived segments without EXT-X-PROGRAM-DATE-TIME.
+// They follow the previous segment, or start at the beginning of the day with cumulative durations.
+func fillSegmentTimes(playlist *streaming.Playlist, date string) error {
+	t, err := time.ParseInLocation(common.DateFormat, date, common.Loc)
+	if err != nil {
+		return err
+	}
+	for _, seg := range playlist.Segments {
+		if seg.Time.IsZero() {
+			seg.Time = t
+		}
+		t = seg.Time.Add(time.Duration(seg.Duration * float64(time.Second)))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	"testing"
+	"time"
+)
+
+func TestFillSegmentTimes(t *testing.T) {
+	common.Loc = time.UTC
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, common.Loc)
+	playlist := streaming.NewPlaylist(streaming.PlaylistEvent)
+	playlist.Segments = []*streaming.PlaylistSegment{
+		{Duration: 10},
+		{Duration: 10.5},
+		{Duration: 10, Time: day.Add(time.Hour)},
+		{Duration: 10},
+	}
+	if err := fillSegmentTimes(playlist, day.Format(common.DateFormat)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Time{day, day.Add(10 * time.Second), day.Add(time.Hour), day.Add(time.Hour + 10*time.Second)}
+	for i, seg := range playlist.Segments {
+		if !seg.Time.Equal(expected[i]) {
+			t.Errorf("segment %d: expected %s, got %s", i, expected[i].Format(time.RFC3339), seg.Time.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// archivedDay is an archived day of a stream which can be evicted
+type archivedDay struct {
+	stream    *streaming.Stream
+	date      string
+	size      int64
+	tier      string
+	protected bool
+}
+
+// getUsage returns bytes of archived and live videos by stream and group
+func (m *Manager) getUsage() (*common.Usage, error) {
+	usage := &common.Usage{
+		Streams: make([]*common.StreamUsage, 0),
+		Groups:  make([]*common.GroupUsage, 0),
+	}
+	groups := make(map[string]*common.GroupUsage)
+	for _, stream := range m.getStreams() {
+		u, err := m.getStreamUsage(stream)
+		if err != nil {
+			return nil, err
+		}
+		usage.Streams = append(usage.Streams, u)
+
+		if len(stream.Group) < 1 {
+			continue
+		}
+		g, ok := groups[stream.Group]
+		if !ok {
+			g = &common.GroupUsage{
+				Group:      stream.Group,
+				Streams:    make([]int64, 0),
+				QuotaBytes: m.server.config.Quota.Groups[stream.Group],
+			}
+			groups[stream.Group] = g
+			usage.Groups = append(usage.Groups, g)
+		}
+		g.Streams = append(g.Streams, stream.Id)
+		g.UsedBytes += u.UsedBytes
+	}
+
+	sort.Slice(usage.Streams, func(i, j int) bool {
+		return usage.Streams[i].StreamId < usage.Streams[j].StreamId
+	})
+	sort.Slice(usage.Groups, func(i, j int) bool {
+		return usage.Groups[i].Group < usage.Groups[j].Group
+	})
+	return usage, nil
+}
+
+func (m *Manager) getStreamUsage(stream *streaming.Stream) (*common.StreamUsage, error) {
+	days, err := m.getArchivedDays(stream)
+	if err != nil {
+		return nil, err
+	}
+	u := &common.StreamUsage{
+		StreamId:   stream.Id,
+		Group:      stream.Group,
+		QuotaBytes: stream.QuotaBytes,
+		LiveBytes:  m.getLiveVideoSize(stream.Id),
+	}
+	for _, d := range days {
+		u.ArchivedBytes += d.size
+	}
+	if len(days) > 0 {
+		u.OldestDate = days[0].date
+	}
+	u.UsedBytes = u.ArchivedBytes + u.LiveBytes
+	return u, nil
+}
+
+// getArchivedDays returns archived days of the stream in ascending order
+func (m *Manager) getArchivedDays(stream *streaming.Stream) ([]*archivedDay, error) {
+	days := make([]*archivedDay, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(fmt.Sprintf("%s%d", common.VideoBucketPrefix, stream.Id)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			day := common.ParseDayArchive(v)
+			days = append(days, &archivedDay{
+				stream:    stream,
+				date:      string(k),
+				size:      day.Size,
+				tier:      day.Tier,
+				protected: day.Protected,
+			})
+			return nil
+		})
+	})
+	return days, err
+}
+
+func (m *Manager) getLiveVideoSize(streamId int64) int64 {
+	files, err := ioutil.ReadDir(filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(streamId, 10)))
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			size += f.Size()
+		}
+	}
+	return size
+}
+
+// enforceQuotas evicts the oldest archived videos of streams and groups exceeding their quota
+func (m *Manager) enforceQuotas() error {
+	usage, err := m.getUsage()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range usage.Streams {
+		if u.QuotaBytes < 1 || u.UsedBytes <= u.QuotaBytes {
+			continue
+		}
+		stream := m.getStreamById(u.StreamId)
+		if stream == nil {
+			continue
+		}
+		if err := m.evictOldestVideos([]*streaming.Stream{stream}, u.UsedBytes-u.QuotaBytes); err != nil {
+			log.Error(err)
+		}
+	}
+
+	// Group usage is computed again since streams in the group may have been evicted
+	usage, err = m.getUsage()
+	if err != nil {
+		return err
+	}
+	for _, g := range usage.Groups {
+		if g.QuotaBytes < 1 || g.UsedBytes <= g.QuotaBytes {
+			continue
+		}
+		streams := make([]*streaming.Stream, 0, len(g.Streams))
+		for _, id := range g.Streams {
+			if stream := m.getStreamById(id); stream != nil {
+				streams = append(streams, stream)
+			}
+		}
+		if err := m.evictOldestVideos(streams, g.UsedBytes-g.QuotaBytes); err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
+}
+
+// evictOldestVideos deletes the oldest archived days of the streams first until the bytes are freed.
+// If the oldest day is larger than needed, its oldest segments are deleted instead.
+// Days being archived are not touched.
+func (m *Manager) evictOldestVideos(streams []*streaming.Stream, bytes int64) error {
+	busy, err := m.getBusyDays()
+	if err != nil {
+		return err
+	}
+	today := time.Now().In(common.Loc).Format(common.DateFormat)
+
+	days := make([]*archivedDay, 0)
+	for _, stream := range streams {
+		list, err := m.getArchivedDays(stream)
+		if err != nil {
+			return err
+		}
+		for _, d := range list {
+			if d.date >= today || d.protected || busy[m.getRecordKey(d.stream.Id, d.date)] {
+				continue
+			}
+			days = append(days, d)
+		}
+	}
+	sort.SliceStable(days, func(i, j int) bool {
+		if days[i].date != days[j].date {
+			return days[i].date < days[j].date
+		}
+		return days[i].stream.Id < days[j].stream.Id
+	})
+
+	for _, d := range days {
+		if bytes <= 0 {
+			return nil
+		}
+		if d.size > bytes {
+			freed, err := m.evictOldestSegments(d, bytes)
+			if err != nil {
+				return err
+			}
+			bytes -= freed
+			continue
+		}
+		if err := m.deleteArchivedDay(d.stream, d.date); err != nil {
+			return err
+		}
+		bytes -= d.size
+		log.WithFields(log.Fields{
+			"streamId": d.stream.Id,
+			"date":     d.date,
+			"size":     d.size,
+		}).Info("[manager] archived videos have been evicted")
+	}
+
+	if bytes > 0 {
+		log.WithFields(log.Fields{
+			"bytes": bytes,
+		}).Warn("[manager] quota is still exceeded; no more archived videos to evict")
+	}
+	return nil
+}
+
+// evictOldestSegments deletes segments from the beginning of the archived day and returns freed bytes
+func (m *Manager) evictOldestSegments(d *archivedDay, bytes int64) (int64, error) {
+	recordKey := m.getRecordKey(d.stream.Id, d.date)
+	backend, err := m.getBackend(d.stream.Id, d.date)
+	if err != nil {
+		return 0, err
+	}
+	playlist, err := m.readArchivedPlaylist(backend, recordKey)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := backend.List(recordKey + "/")
+	if err != nil {
+		return 0, err
+	}
+	sizes := make(map[string]int64)
+	for _, obj := range objects {
+		sizes[filepath.Base(obj.Key)] = obj.Size
+	}
+
+	var freed int64
+	n := 0
+	for n < len(playlist.Segments)-1 && freed < bytes {
+		freed += sizes[filepath.Base(playlist.Segments[n].URI)]
+		n++
+	}
+	if n < 1 {
+		return 0, nil
+	}
+	if err := m.deleteLeadingSegments(d.stream, d.date, playlist, n); err != nil {
+		return 0, err
+	}
+
+	log.WithFields(log.Fields{
+		"streamId": d.stream.Id,
+		"date":     d.date,
+		"segments": n,
+		"size":     freed,
+	}).Info("[manager] archived segments have been evicted")
+	return freed, nil
+}
+
+// getBusyDays returns record keys of days which have unfinished archive, move or transcode jobs
+func (m *Manager) getBusyDays() (map[string]bool, error) {
+	jobs, err := m.jobs.list(func(job *common.Job) bool {
+		return job.Type != common.JobExport && !job.IsFinished()
+	})
+	if err != nil {
+		return nil, err
+	}
+	busy := make(map[string]bool)
+	for _, job := range jobs {
+		date := time.Unix(job.From, 0).In(common.Loc).Format(common.DateFormat)
+		busy[m.getRecordKey(job.StreamId, date)] = true
+	}
+	return busy, nil
+}
@@ -5,52 +5,149 @@ import (
 )
 
 func (c *Controller) initRouter() {
+	c.setAuthRoutes()
 	c.setApiRoutes()
 	c.setAssetRoutes()
 	c.setDatabaseRoutes()
 	c.setUiRoutes()
 }
 
+// Routes are allowed to users of the role or above and API tokens of the scope; see permission
+func (c *Controller) setAuthRoutes() {
+	// Login: http://127.0.0.1:8000/login
+	c.router.HandleFunc("/login", c.DisplayLogin).Methods("GET")
+	c.router.HandleFunc("/login", c.Login).Methods("POST")
+	c.router.HandleFunc("/logout", c.Logout).Methods("POST")
+
+	// Current user: http://127.0.0.1:8000/me
+	c.router.HandleFunc("/me", c.authorize(permPage, c.GetMe)).Methods("GET")
+	c.router.HandleFunc("/me/password", c.authorize(permPage, c.ChangePassword)).Methods("PATCH")
+
+	// Users: http://127.0.0.1:8000/users
+	c.router.HandleFunc("/users", c.authorize(permAdmin, c.GetUsers)).Methods("GET")
+	c.router.HandleFunc("/users", c.authorize(permAdmin, c.AddUser)).Methods("POST")
+	c.router.HandleFunc("/users/{username}", c.authorize(permAdmin, c.UpdateUser)).Methods("PATCH")
+	c.router.HandleFunc("/users/{username}", c.authorize(permAdmin, c.DeleteUser)).Methods("DELETE")
+	c.router.HandleFunc("/users/{username}/acl", c.authorize(permAdmin, c.SetUserAcl)).Methods("PUT")
+
+	// API tokens: http://127.0.0.1:8000/tokens
+	c.router.HandleFunc("/tokens", c.authorize(permAdmin, c.GetTokens)).Methods("GET")
+	c.router.HandleFunc("/tokens", c.authorize(permAdmin, c.AddToken)).Methods("POST")
+	c.router.HandleFunc("/tokens/{tokenId:[0-9]+}", c.authorize(permAdmin, c.DeleteToken)).Methods("DELETE")
+}
+
 func (c *Controller) setUiRoutes() {
-	c.router.HandleFunc("/streams/", c.DisplayStreams).Methods("GET")
-	c.router.HandleFunc("/videos/", c.DisplayVideos).Methods("GET")
-	c.router.HandleFunc("/live/", c.DisplayLive).Methods("GET")
-	c.router.HandleFunc("/tpl", serveTemplate2)
+	c.router.HandleFunc("/streams/", c.authorize(permOperatorPage, c.DisplayStreams)).Methods("GET")
+	c.router.HandleFunc("/videos/", c.authorize(permPage, c.DisplayVideos)).Methods("GET")
+	c.router.HandleFunc("/live/", c.authorize(permPage, c.DisplayLive)).Methods("GET")
+	c.router.HandleFunc("/tpl", c.authorize(permAdmin, serveTemplate2))
 }
 
 func (c *Controller) setApiRoutes() {
 	//r.HandleFunc("/test", c.Test).Methods("GET")
 
-	c.router.HandleFunc("/streams", c.GetStreams).Methods("GET")
-	c.router.HandleFunc("/streams", c.AddStream).Methods("POST")
-	c.router.HandleFunc("/streams/debug", c.DebugStream).Methods("GET")
-	c.router.HandleFunc("/streams/{id:[0-9]+}", c.GetStreamById).Methods("GET")
-	c.router.HandleFunc("/streams/{id:[0-9]+}", c.UpdateStream).Methods("PATCH")
-	c.router.HandleFunc("/streams/{id:[0-9]+}", c.DeleteStream).Methods("DELETE")
-	c.router.HandleFunc("/streams/{id:[0-9]+}/start", c.StartStream).Methods("GET")
-	c.router.HandleFunc("/streams/{id:[0-9]+}/stop", c.StopStream).Methods("GET")
+	c.router.HandleFunc("/streams", c.authorize(permStreamsRead, c.GetStreams)).Methods("GET")
+	c.router.HandleFunc("/streams", c.authorize(permStreamsWrite, c.AddStream)).Methods("POST")
+	c.router.HandleFunc("/streams/debug", c.authorize(permStreamsWrite, c.DebugStream)).Methods("GET")
+	c.router.HandleFunc("/streams/{id:[0-9]+}", c.authorize(permStreamsRead, c.GetStreamById)).Methods("GET")
+	c.router.HandleFunc("/streams/{id:[0-9]+}", c.authorize(permStreamsWrite, c.UpdateStream)).Methods("PATCH")
+	c.router.HandleFunc("/streams/{id:[0-9]+}", c.authorize(permStreamsWrite, c.DeleteStream)).Methods("DELETE")
+	// Not GET, so that cross-site links can't control streams with session cookies(SameSite=Lax)
+	c.router.HandleFunc("/streams/{id:[0-9]+}/start", c.authorize(permStreamControl, c.StartStream)).Methods("POST")
+	c.router.HandleFunc("/streams/{id:[0-9]+}/stop", c.authorize(permStreamControl, c.StopStream)).Methods("POST")
 
 	// Video records
-	c.router.HandleFunc("/videos", c.GetVideoRecords).Methods("GET")
+	c.router.HandleFunc("/videos", c.authorize(permMediaRead, c.GetVideoRecords)).Methods("GET")
 
 	// Today M3u8: http://127.0.0.1:8000/videos/1/today/m3u8
-	c.router.HandleFunc("/videos/{id:[0-9]+}/today/m3u8", c.GetTodayM3u8).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/today/m3u8", c.authorize(permMediaRead, c.GetTodayM3u8)).Methods("GET")
 	// Today videos: http://127.0.0.1:8000/videos/1/today/media0.ts
-	c.router.HandleFunc("/videos/{id:[0-9]+}/today/{media}.ts", c.GetTodayVideo).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/today/{media}.ts", c.authorize(permMediaRead, c.GetTodayVideo)).Methods("GET")
 
 	// (O) Live M3u8: http://127.0.0.1:8000/videos/1/live/m3u8
-	c.router.HandleFunc("/live/{id:[0-9]+}/m3u8", c.GetLiveM3u8).Methods("GET")
+	c.router.HandleFunc("/live/{id:[0-9]+}/m3u8", c.authorize(permLive, c.GetLiveM3u8)).Methods("GET")
 	// (O) Live videos: http://127.0.0.1:8000/videos/1/live/media0.ts
-	c.router.HandleFunc("/live/{id:[0-9]+}/{media}.ts", c.GetLiveVideo).Methods("GET")
+	c.router.HandleFunc("/live/{id:[0-9]+}/{media}.ts", c.authorize(permLive, c.GetLiveVideo)).Methods("GET")
 
 	// Old M3u8: http://127.0.0.1:8000/videos/1/date/20191211/m3u8
-	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/m3u8", c.GetDailyM3u8).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/m3u8", c.authorize(permMediaRead, c.GetDailyM3u8)).Methods("GET")
 	// Old videos: http://127.0.0.1:8000/videos/1/date/20191211/media0.ts
-	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/{media}.ts", c.GetDailyVideo).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/{media}.ts", c.authorize(permMediaRead, c.GetDailyVideo)).Methods("GET")
+
+	// I-frame only M3u8: http://127.0.0.1:8000/videos/1/date/20191211/iframe/m3u8
+	c.router.HandleFunc("/videos/{id:[0-9]+}/today/iframe/m3u8", c.authorize(permMediaRead, c.GetTodayIframeM3u8)).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/iframe/m3u8", c.authorize(permMediaRead, c.GetDailyIframeM3u8)).Methods("GET")
+
+	// Time range M3u8 across days: http://127.0.0.1:8000/videos/1/m3u8?from=2019-12-11T23:55:00%2B09:00&to=2019-12-12T00:10:00%2B09:00
+	c.router.HandleFunc("/videos/{id:[0-9]+}/m3u8", c.authorize(permMediaRead, c.GetRangeM3u8)).Methods("GET")
+
+	// Synchronized playback of streams: http://127.0.0.1:8000/videos/sync?ids=1,2,3&from=2019-12-11T23:55:00%2B09:00&to=2019-12-12T00:10:00%2B09:00
+	c.router.HandleFunc("/videos/sync", c.authorize(permMediaRead, c.GetSyncPlayback)).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/sync/m3u8", c.authorize(permMediaRead, c.GetSyncM3u8)).Methods("GET")
+	// Filler videos padding gaps of synchronized playlists: http://127.0.0.1:8000/videos/filler/60.ts
+	c.router.HandleFunc("/videos/filler/{seconds:[0-9]+}.ts", c.authorize(permMediaRead, c.GetFillerVideo)).Methods("GET")
+
+	// Timeline: http://127.0.0.1:8000/videos/1/timeline?from=20191211&to=20191212
+	c.router.HandleFunc("/videos/{id:[0-9]+}/timeline", c.authorize(permMediaRead, c.GetTimeline)).Methods("GET")
+
+	// Annotations: http://127.0.0.1:8000/annotations?ids=1,2&tag=door&from=20191211&to=20191212
+	c.router.HandleFunc("/annotations", c.authorize(permMediaRead, c.SearchAnnotations)).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/annotations", c.authorize(permMediaRead, c.SearchAnnotations)).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/annotations", c.authorize(permAnnotate, c.AddAnnotation)).Methods("POST")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/annotations/vtt", c.authorize(permMediaRead, c.GetAnnotationVtt)).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/annotations/{annotationId:[0-9]+}", c.authorize(permMediaRead, c.GetAnnotation)).Methods("GET")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/annotations/{annotationId:[0-9]+}", c.authorize(permAnnotate, c.UpdateAnnotation)).Methods("PATCH")
+	c.router.HandleFunc("/videos/{id:[0-9]+}/annotations/{annotationId:[0-9]+}", c.authorize(permAnnotate, c.DeleteAnnotation)).Methods("DELETE")
+
+	// Integrity: http://127.0.0.1:8000/videos/1/date/20191211/verify
+	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/verify", c.authorize(permVerify, c.VerifyDailyVideos)).Methods("GET")
+
+	// Hash chain: http://127.0.0.1:8000/videos/1/date/20191211/chain
+	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/chain", c.authorize(permVerify, c.GetChainStatus)).Methods("GET")
+
+	// Disk watchdog: http://127.0.0.1:8000/disk
+	c.router.HandleFunc("/disk", c.authorize(permSystemRead, c.GetDiskStatus)).Methods("GET")
+
+	// Protect archived day from being purged: http://127.0.0.1:8000/videos/1/date/20191211/protect
+	c.router.HandleFunc("/videos/{id:[0-9]+}/date/{date:[0-9]+}/protect", c.authorize(permArchive, c.ProtectDailyVideos)).Methods("POST", "DELETE")
+
+	// Encryption key rotation: http://127.0.0.1:8000/storage/keys/rotate
+	c.router.HandleFunc("/storage/keys/rotate", c.authorize(permAdmin, c.RotateEncryptionKey)).Methods("POST")
+
+	// Storage usage and quota: http://127.0.0.1:8000/usage
+	c.router.HandleFunc("/usage", c.authorize(permSystemRead, c.GetUsage)).Methods("GET")
+
+	// Archive progress by stream: http://127.0.0.1:8000/archive/progress
+	c.router.HandleFunc("/archive/progress", c.authorize(permArchive, c.GetArchiveProgress)).Methods("GET")
+
+	// Archive jobs: http://127.0.0.1:8000/archive/jobs?id=1&status=failed
+	c.router.HandleFunc("/videos/{id:[0-9]+}/archive", c.authorize(permArchive, c.ArchiveVideos)).Methods("POST")
+	c.router.HandleFunc("/archive/jobs", c.authorize(permArchive, c.GetArchiveJobs)).Methods("GET")
+	c.router.HandleFunc("/archive/jobs/{jobId:[0-9]+}", c.authorize(permArchive, c.GetArchiveJob)).Methods("GET")
+	c.router.HandleFunc("/archive/jobs/{jobId:[0-9]+}", c.authorize(permArchive, c.CancelArchiveJob)).Methods("DELETE")
+	c.router.HandleFunc("/archive/jobs/{jobId:[0-9]+}/retry", c.authorize(permArchive, c.RetryArchiveJob)).Methods("POST")
+
+	// Export: http://127.0.0.1:8000/videos/1/export?from=20191211&to=20191212
+	c.router.HandleFunc("/videos/{id:[0-9]+}/export", c.authorize(permExport, c.ExportVideos)).Methods("POST")
+	c.router.HandleFunc("/exports", c.authorize(permExport, c.GetExportJobs)).Methods("GET")
+	c.router.HandleFunc("/exports/key", c.authorize(permExport, c.GetExportPublicKey)).Methods("GET")
+	c.router.HandleFunc("/exports/{jobId:[0-9]+}", c.authorize(permExport, c.GetExportJob)).Methods("GET")
+	c.router.HandleFunc("/exports/{jobId:[0-9]+}", c.authorize(permExport, c.CancelExportJob)).Methods("DELETE")
+	c.router.HandleFunc("/exports/{jobId:[0-9]+}/download", c.authorize(permExport, c.DownloadExportedVideo)).Methods("GET")
+
+	// Shares: http://127.0.0.1:8000/shares
+	c.router.HandleFunc("/videos/{id:[0-9]+}/shares", c.authorize(permExport, c.AddShare)).Methods("POST")
+	c.router.HandleFunc("/shares", c.authorize(permExport, c.GetShares)).Methods("GET")
+	c.router.HandleFunc("/shares/{shareId:[0-9]+}", c.authorize(permExport, c.RevokeShare)).Methods("DELETE")
+	// Shared URLs are signed instead of login: http://127.0.0.1:8000/share/1/m3u8?exp=1576162800&sig=...
+	c.router.HandleFunc("/share/{shareId:[0-9]+}/m3u8", c.verifyShare(c.GetSharedM3u8)).Methods("GET")
+	c.router.HandleFunc("/share/{shareId:[0-9]+}/live/{media}.ts", c.verifyShare(c.GetSharedLiveVideo)).Methods("GET")
+	c.router.HandleFunc("/share/{shareId:[0-9]+}/today/{media}.ts", c.verifyShare(c.GetSharedLiveVideo)).Methods("GET")
+	c.router.HandleFunc("/share/{shareId:[0-9]+}/date/{date:[0-9]+}/{media}.ts", c.verifyShare(c.GetSharedDailyVideo)).Methods("GET")
 
 	c.router.
 		PathPrefix("/static").
-		Handler(http.StripPrefix("/static", http.FileServer(http.Dir(c.staticDir))))
+		Handler(c.authorize(permPage, http.StripPrefix("/static", http.FileServer(http.Dir(c.staticDir))).ServeHTTP))
 }
 
 func (c *Controller) setAssetRoutes() {
@@ -71,20 +168,12 @@ func (c *Controller) setAssetRoutes() {
 		/assets/plugins/moment/moment.min.js
 	*/
 
-	c.router.HandleFunc("/assets/{assetType}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		GetAsset(w, r)
-	})
+	c.router.HandleFunc("/assets/{assetType}/{name}", c.authorize(permPage, GetAsset))
 
-	c.router.HandleFunc("/assets/plugins/{pluginName}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		GetAsset(w, r)
-	})
-	c.router.HandleFunc("/assets/plugins/{pluginName}/{kind}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		GetAsset(w, r)
-	})
+	c.router.HandleFunc("/assets/plugins/{pluginName}/{name}", c.authorize(permPage, GetAsset))
+	c.router.HandleFunc("/assets/plugins/{pluginName}/{kind}/{name}", c.authorize(permPage, GetAsset))
 
-	c.router.HandleFunc("/assets/modules/{moduleName}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		GetAsset(w, r)
-	})
+	c.router.HandleFunc("/assets/modules/{moduleName}/{name}", c.authorize(permPage, GetAsset))
 }
 
 func (c *Controller) setDatabaseRoutes() {
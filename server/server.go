@@ -4,7 +4,7 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/devplayg/hippo"
 	"github.com/devplayg/rtsp-stream/common"
-	"github.com/minio/minio-go"
+	"github.com/devplayg/rtsp-stream/storage"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"path/filepath"
@@ -14,12 +14,16 @@ import (
 var db *bolt.DB
 
 type Server struct {
-	engine     *hippo.Engine  // Server framework
-	controller *Controller    // Controller
-	manager    *Manager       // Stream manager
-	addr       string         // Service address
-	dbDir      string         // Database directory
-	config     *common.Config // config
+	engine     *hippo.Engine       // Server framework
+	controller *Controller         // Controller
+	manager    *Manager            // Stream manager
+	addr       string              // Service address
+	dbDir      string              // Database directory
+	config     *common.Config      // config
+	storage    storage.Backend     // Archived video storage
+	cold       storage.Backend     // Cold storage of old archived videos (nil: disabled)
+	keys       storage.KeyProvider // Keys of encrypted storage (nil: disabled)
+	shareKey   []byte              // HMAC key of shared URLs
 }
 
 func NewServer(config *common.Config) *Server {
@@ -50,6 +54,7 @@ func (s *Server) Start() error {
 		"liveDir":           absLiveDir,
 		"recordDir":         absRecordDir,
 		"bucket":            s.config.Storage.Bucket,
+		"remote":            s.config.Storage.Remote,
 		"staticDir":         s.config.StaticDir,
 		"dataRetentionDays": s.config.DataRetentionDays,
 	}).Infof("[server] listening on %s", s.addr)
@@ -85,6 +90,14 @@ func (s *Server) init() error {
 		return err
 	}
 
+	if err := s.initUsers(); err != nil {
+		return err
+	}
+
+	if err := s.initShares(); err != nil {
+		return err
+	}
+
 	if err := s.initDirectories(); err != nil {
 		return err
 	}
@@ -115,6 +128,10 @@ func (s *Server) initDirectories() error {
 		return err
 	}
 
+	if err := hippo.EnsureDir(s.config.Export.Dir); err != nil {
+		return err
+	}
+
 	if !s.config.Storage.Remote {
 		if err := hippo.EnsureDir(s.config.Storage.RecordDir); err != nil {
 			return err
@@ -172,18 +189,59 @@ func (s *Server) initDatabase() error {
 }
 
 func (s *Server) initStorage() error {
-	client, err := minio.New(s.config.Storage.Address, s.config.Storage.AccessKey, s.config.Storage.SecretKey, s.config.Storage.UseSSL)
+	backend, err := storage.New(&storage.Options{
+		Remote:    s.config.Storage.Remote,
+		Address:   s.config.Storage.Address,
+		AccessKey: s.config.Storage.AccessKey,
+		SecretKey: s.config.Storage.SecretKey,
+		Bucket:    s.config.Storage.Bucket,
+		UseSSL:    s.config.Storage.UseSSL,
+		RecordDir: filepath.Join(s.config.Storage.RecordDir, s.config.Storage.Bucket),
+	})
 	if err != nil {
 		log.WithFields(log.Fields{
+			"remote":    s.config.Storage.Remote,
 			"address":   s.config.Storage.Address,
 			"accessKey": s.config.Storage.AccessKey,
-		}).Error("failed to connect to object storage")
+		}).Error("failed to initialize storage")
 		return err
 	}
-	common.MinioClient = client
+	s.storage = backend
+
+	if s.config.Encryption.Enabled {
+		keyDir := s.config.Encryption.KeyDir
+		if len(keyDir) < 1 {
+			keyDir = filepath.Join(s.dbDir, "keys")
+		}
+		keys, err := storage.NewFileKeyProvider(keyDir)
+		if err != nil {
+			return err
+		}
+		s.keys = keys
+		s.storage = storage.NewEncryptedStorage(backend, keys)
+	}
 
-	if len(s.config.Storage.Bucket) > 0 {
-		common.VideoRecordBucket = s.config.Storage.Bucket
+	if s.config.Tier.ColdAfterDays < 1 {
+		return nil
+	}
+	cold, err := storage.New(&storage.Options{
+		Remote:    true,
+		Address:   s.config.Tier.Cold.Address,
+		AccessKey: s.config.Tier.Cold.AccessKey,
+		SecretKey: s.config.Tier.Cold.SecretKey,
+		Bucket:    s.config.Tier.Cold.Bucket,
+		UseSSL:    s.config.Tier.Cold.UseSSL,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"address":   s.config.Tier.Cold.Address,
+			"accessKey": s.config.Tier.Cold.AccessKey,
+		}).Error("failed to initialize cold storage")
+		return err
+	}
+	s.cold = cold
+	if s.keys != nil {
+		s.cold = storage.NewEncryptedStorage(cold, s.keys)
 	}
 	return nil
 }
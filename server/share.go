@@ -0,0 +1,222 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShareDuration = 24 * time.Hour
+	maxShareDuration     = 30 * 24 * time.Hour
+)
+
+// initShares loads the HMAC key of shared URLs; it's generated on first start
+func (s *Server) initShares() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(common.ShareBucket); err != nil {
+			return err
+		}
+		b := tx.Bucket(common.ConfigBucket)
+		if key := b.Get(common.ShareKey); len(key) > 0 {
+			s.shareKey = append([]byte{}, key...)
+			return nil
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		s.shareKey = key
+		return b.Put(common.ShareKey, key)
+	})
+}
+
+// addShare saves the share of the live view (if live) or the recorded range
+func (m *Manager) addShare(share *common.Share) error {
+	if m.getStreamById(share.StreamId) == nil {
+		return common.ErrorStreamNotFound
+	}
+	now := time.Now()
+	if share.Expires == 0 {
+		share.Expires = now.Add(defaultShareDuration).Unix()
+	}
+	if share.Expires <= now.Unix() || time.Unix(share.Expires, 0).Sub(now) > maxShareDuration {
+		return common.ErrorInvalidShare
+	}
+	if share.Live {
+		share.From, share.To = 0, 0
+	} else if share.From < 1 || share.To <= share.From || time.Duration(share.To-share.From)*time.Second > maxPlaylistDuration {
+		return common.ErrorInvalidTime
+	}
+	share.Created = now.Unix()
+	share.Revoked = 0
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.ShareBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		share.Id = int64(id)
+		data, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return b.Put(common.Int64ToBytes(share.Id), data)
+	})
+}
+
+func getShare(id int64) (*common.Share, error) {
+	var share *common.Share
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(common.ShareBucket).Get(common.Int64ToBytes(id))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &share)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if share == nil {
+		return nil, common.ErrorShareNotFound
+	}
+	return share, nil
+}
+
+func getShares() ([]*common.Share, error) {
+	shares := make([]*common.Share, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(common.ShareBucket).ForEach(func(k, v []byte) error {
+			var share common.Share
+			if err := json.Unmarshal(v, &share); err != nil {
+				return err
+			}
+			shares = append(shares, &share)
+			return nil
+		})
+	})
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].Id > shares[j].Id
+	})
+	return shares, err
+}
+
+// revokeShare invalidates all URLs of the share at once
+func revokeShare(id int64) (*common.Share, error) {
+	var share *common.Share
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.ShareBucket)
+		data := b.Get(common.Int64ToBytes(id))
+		if data == nil {
+			return common.ErrorShareNotFound
+		}
+		if err := json.Unmarshal(data, &share); err != nil {
+			return err
+		}
+		if share.Revoked == 0 {
+			share.Revoked = time.Now().Unix()
+		}
+		data, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return b.Put(common.Int64ToBytes(id), data)
+	})
+	return share, err
+}
+
+func (s *Server) signSharePath(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.shareKey)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signShareUrl returns the path with its expiry and signature.
+// Every URL of a share has its own signature, so a signed URL can't be used for other files.
+func (s *Server) signShareUrl(path string, expires int64) string {
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("sig", s.signSharePath(path, expires))
+	return path + "?" + query.Encode()
+}
+
+// verifyShareUrl returns the share if the signature of the URL is valid and the share is neither expired nor revoked
+func (s *Server) verifyShareUrl(u *url.URL, shareId int64) (*common.Share, error) {
+	expires, err := strconv.ParseInt(u.Query().Get("exp"), 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		return nil, common.ErrorInvalidSignature
+	}
+	sig, err := hex.DecodeString(u.Query().Get("sig"))
+	if err != nil {
+		return nil, common.ErrorInvalidSignature
+	}
+	expected, _ := hex.DecodeString(s.signSharePath(u.Path, expires))
+	if !hmac.Equal(sig, expected) {
+		return nil, common.ErrorInvalidSignature
+	}
+
+	share, err := getShare(shareId)
+	if err == common.ErrorShareNotFound {
+		return nil, common.ErrorInvalidSignature
+	}
+	if err != nil {
+		return nil, err
+	}
+	if share.Revoked > 0 || share.Expires < time.Now().Unix() {
+		return nil, common.ErrorInvalidSignature
+	}
+	return share, nil
+}
+
+func getSharePath(share *common.Share) string {
+	return "/share/" + strconv.FormatInt(share.Id, 10)
+}
+
+// getSharePlaylist returns the playlist of the share whose segment URIs are signed URLs of the share
+func (m *Manager) getSharePlaylist(share *common.Share) (*streaming.Playlist, error) {
+	id := strconv.FormatInt(share.StreamId, 10)
+	sharePath := getSharePath(share)
+
+	if share.Live {
+		file, err := os.Open(filepath.Join(m.server.config.Storage.LiveDir, id, common.LiveM3u8FileName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, common.ErrorNoVideos
+			}
+			return nil, err
+		}
+		defer file.Close()
+		playlist, err := streaming.ParsePlaylist(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, seg := range playlist.Segments {
+			seg.URI = m.server.signShareUrl(sharePath+"/live/"+filepath.Base(seg.URI), share.Expires)
+		}
+		return playlist, nil
+	}
+
+	playlist, err := m.getRangePlaylist(share.StreamId, time.Unix(share.From, 0).In(common.Loc), time.Unix(share.To, 0).In(common.Loc))
+	if err != nil {
+		return nil, err
+	}
+	prefix := "/videos/" + id + "/"
+	for _, seg := range playlist.Segments {
+		// "/videos/{id}/date/{date}/{name}" or "/videos/{id}/today/{name}"
+		seg.URI = m.server.signShareUrl(sharePath+"/"+strings.TrimPrefix(seg.URI, prefix), share.Expires)
+	}
+	return playlist, nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/devplayg/hippo"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxSyncStreams    = 16
+	maxFillerDuration = 60 // seconds
+)
+
+// Filler videos are made once and shared by all playlists
+var fillerLock sync.Mutex
+
+// getSyncPlayback returns playlists of the streams which all begin at "from";
+// gaps are padded with filler videos so that the same position of players means the same time.
+func (m *Manager) getSyncPlayback(ids []int64, from, to time.Time) (*common.SyncPlayback, error) {
+	if len(ids) < 1 || len(ids) > maxSyncStreams {
+		return nil, common.ErrorInvalidStream
+	}
+	if !from.Before(to) || to.Sub(from) > maxPlaylistDuration {
+		return nil, common.ErrorInvalidTime
+	}
+
+	playback := &common.SyncPlayback{
+		From:    from.Unix(),
+		To:      to.Unix(),
+		Streams: make([]*common.SyncStream, 0, len(ids)),
+	}
+	for _, id := range ids {
+		stream := m.getStreamById(id)
+		if stream == nil {
+			return nil, common.ErrorStreamNotFound
+		}
+		playlist, err := m.getSyncPlaylist(id, from, to)
+		if err != nil {
+			return nil, err
+		}
+		ranges, err := getRecordedRanges(stream, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &common.SyncStream{
+			Id:       id,
+			Name:     stream.Name,
+			Playlist: fmt.Sprintf("/videos/%d/sync/m3u8?from=%d&to=%d", id, from.Unix(), to.Unix()),
+			Ranges:   ranges,
+		}
+		if len(playlist.Segments) > 0 && playlist.Segments[0].Time.Before(from) {
+			s.Offset = from.Sub(playlist.Segments[0].Time).Seconds()
+		}
+		playback.Streams = append(playback.Streams, s)
+	}
+	return playback, nil
+}
+
+// getSyncPlaylist returns the time range playlist padded from "from"
+func (m *Manager) getSyncPlaylist(streamId int64, from, to time.Time) (*streaming.Playlist, error) {
+	playlist, err := m.getRangePlaylist(streamId, from, to)
+	if err == common.ErrorNoVideos {
+		playlist = streaming.NewPlaylist(streaming.PlaylistVod)
+		playlist.Ended = true
+	} else if err != nil {
+		return nil, err
+	}
+	return padPlaylist(playlist, from, to), nil
+}
+
+// padPlaylist fills the gaps of the playlist with filler videos.
+// The end of an open range is not padded, because the playlist grows.
+func padPlaylist(playlist *streaming.Playlist, from, to time.Time) *streaming.Playlist {
+	segments := make([]*streaming.PlaylistSegment, 0, len(playlist.Segments))
+	cursor := from
+	for _, seg := range playlist.Segments {
+		if gap := seg.Time.Sub(cursor).Seconds(); gap > minTimelineTolerance {
+			segments = append(segments, getFillerSegments(cursor, gap)...)
+			seg.Discontinuity = true
+		}
+		segments = append(segments, seg)
+		if end := seg.Time.Add(time.Duration(seg.Duration * float64(time.Second))); end.After(cursor) {
+			cursor = end
+		}
+	}
+	if playlist.Ended {
+		if gap := to.Sub(cursor).Seconds(); gap > minTimelineTolerance {
+			segments = append(segments, getFillerSegments(cursor, gap)...)
+		}
+	}
+	playlist.Segments = segments
+	return playlist
+}
+
+// getFillerSegments splits the gap into filler videos of whole seconds
+func getFillerSegments(start time.Time, seconds float64) []*streaming.PlaylistSegment {
+	segments := make([]*streaming.PlaylistSegment, 0)
+	t := start
+	for n := int(math.Round(seconds)); n > 0; {
+		d := n
+		if d > maxFillerDuration {
+			d = maxFillerDuration
+		}
+		segments = append(segments, &streaming.PlaylistSegment{
+			URI:           "/videos/filler/" + strconv.Itoa(d) + common.VideoFileExt,
+			Duration:      float64(d),
+			Time:          t,
+			Discontinuity: true,
+		})
+		t = t.Add(time.Duration(d) * time.Second)
+		n -= d
+	}
+	return segments
+}
+
+// getFillerVideo returns the path of the filler video; it's made on first use
+func (m *Manager) getFillerVideo(seconds int) (string, error) {
+	if seconds < 1 || seconds > maxFillerDuration {
+		return "", common.ErrorInvalidTime
+	}
+	dir := filepath.Join(m.server.config.Storage.LiveDir, ".filler")
+	path := filepath.Join(dir, strconv.Itoa(seconds)+common.VideoFileExt)
+
+	fillerLock.Lock()
+	defer fillerLock.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := hippo.EnsureDir(dir); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tmp := path + ".tmp"
+	if output, err := MakeFillerVideo(ctx, tmp, seconds); err != nil {
+		log.WithFields(log.Fields{
+			"seconds": seconds,
+		}).Error(getOutputTail(output))
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
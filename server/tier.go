@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"sort"
+	"time"
+)
+
+func (m *Manager) getDayArchive(streamId int64, date string) (*common.DayArchive, error) {
+	var day *common.DayArchive
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(fmt.Sprintf("%s%d", common.VideoBucketPrefix, streamId)))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(date)); v != nil {
+			day = common.ParseDayArchive(v)
+		}
+		return nil
+	})
+	return day, err
+}
+
+func (m *Manager) updateDayArchive(streamId int64, date string, update func(day *common.DayArchive)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(fmt.Sprintf("%s%d", common.VideoBucketPrefix, streamId)))
+		if b == nil {
+			return common.ErrorNoVideos
+		}
+		v := b.Get([]byte(date))
+		if v == nil {
+			return common.ErrorNoVideos
+		}
+		day := common.ParseDayArchive(v)
+		update(day)
+		data, err := json.Marshal(day)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(date), data)
+	})
+}
+
+// getBackend returns the storage which holds archived videos of the day.
+// Days in the cold tier are never read from or written to the hot storage, even if the cold storage is unavailable.
+func (m *Manager) getBackend(streamId int64, date string) (storage.Backend, error) {
+	day, err := m.getDayArchive(streamId, date)
+	if err != nil {
+		return nil, err
+	}
+	if day == nil || day.Tier != common.TierCold {
+		return m.server.storage, nil
+	}
+	if m.server.cold == nil {
+		return nil, common.ErrorColdUnavailable
+	}
+	return m.server.cold, nil
+}
+
+// addMoveJobs queues jobs moving archived days older than "tier.coldAfterDays" to the cold storage
+func (m *Manager) addMoveJobs() error {
+	if m.server.cold == nil {
+		return nil
+	}
+	busy, err := m.getBusyDays()
+	if err != nil {
+		return err
+	}
+
+	t := time.Now().In(common.Loc).AddDate(0, 0, -m.server.config.Tier.ColdAfterDays).Format(common.DateFormat)
+	jobs := make([]*common.Job, 0)
+	for _, stream := range m.getStreams() {
+		days, err := m.getArchivedDays(stream)
+		if err != nil {
+			return err
+		}
+		for _, d := range days {
+			if d.date >= t || busy[m.getRecordKey(stream.Id, d.date)] {
+				continue
+			}
+			day, err := m.getDayArchive(stream.Id, d.date)
+			if err != nil {
+				return err
+			}
+			if day == nil || day.Tier == common.TierCold {
+				continue
+			}
+			from, err := time.ParseInLocation(common.DateFormat, d.date, common.Loc)
+			if err != nil {
+				continue
+			}
+			jobs = append(jobs, common.NewJob(common.JobMove, stream.Id, from, from.AddDate(0, 0, 1), m.server.config.Archive.MaxAttempts))
+		}
+	}
+	if len(jobs) < 1 {
+		return nil
+	}
+	return m.jobs.add(jobs...)
+}
+
+// runMoveJob copies archived videos of the day to the cold storage, verifies them and then deletes them from the hot storage
+func (m *Manager) runMoveJob(ctx context.Context, job *common.Job) error {
+	if m.server.cold == nil {
+		return common.ErrorNoColdStorage
+	}
+	date := time.Unix(job.From, 0).In(common.Loc).Format(common.DateFormat)
+	day, err := m.getDayArchive(job.StreamId, date)
+	if err != nil {
+		return err
+	}
+	if day == nil {
+		// Deleted by retention or quota
+		return nil
+	}
+	recordKey := m.getRecordKey(job.StreamId, date)
+	hot, cold := m.server.storage, m.server.cold
+
+	if day.Tier != common.TierCold {
+		objects, err := hot.List(recordKey + "/")
+		if err != nil {
+			return err
+		}
+		job.Result = &common.JobResult{InputFiles: len(objects)}
+		for _, obj := range objects {
+			job.Result.InputBytes += obj.Size
+		}
+
+		// The playlist is copied last like archiving
+		sort.SliceStable(objects, func(i, j int) bool {
+			return path.Base(objects[j].Key) == common.LiveM3u8FileName
+		})
+		for _, obj := range objects {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := copyObject(hot, cold, obj); err != nil {
+				return err
+			}
+			job.Result.OutputFiles++
+			job.Result.OutputBytes += obj.Size
+		}
+		if err := verifyCopiedObjects(hot, cold, objects); err != nil {
+			return err
+		}
+
+		// Requests are served from the cold storage from now on
+		err = m.updateDayArchive(job.StreamId, date, func(day *common.DayArchive) {
+			day.Tier = common.TierCold
+			day.Moved = time.Now().Unix()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	count, err := storage.DeletePrefix(hot, recordKey+"/")
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"streamId": job.StreamId,
+		"date":     date,
+		"files":    count,
+	}).Info("[manager] archived videos have been moved to the cold storage")
+	return nil
+}
+
+func copyObject(src, dst storage.Backend, obj *storage.ObjectInfo) error {
+	info, err := src.Stat(obj.Key)
+	if err != nil {
+		return err
+	}
+	object, err := src.Get(obj.Key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	return dst.Put(obj.Key, object, info.Size, common.DetectContentType(obj.Key))
+}
+
+// verifyCopiedObjects compares sizes and hashes of the copied objects
+func verifyCopiedObjects(src, dst storage.Backend, objects []*storage.ObjectInfo) error {
+	for _, obj := range objects {
+		srcInfo, err := src.Stat(obj.Key)
+		if err != nil {
+			return err
+		}
+		info, err := dst.Stat(obj.Key)
+		if err != nil {
+			return err
+		}
+		if info.Size != srcInfo.Size {
+			return fmt.Errorf("size mismatch: %s (%d != %d)", obj.Key, info.Size, srcInfo.Size)
+		}
+		srcHash, err := getObjectHash(src, obj.Key)
+		if err != nil {
+			return err
+		}
+		dstHash, err := getObjectHash(dst, obj.Key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(srcHash, dstHash) {
+			return fmt.Errorf("hash mismatch: %s", obj.Key)
+		}
+	}
+	return nil
+}
+
+func getObjectHash(b storage.Backend, key string) ([]byte, error) {
+	object, err := b.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return streaming.GetHash(object)
+}
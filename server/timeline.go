@@ -0,0 +1,176 @@
+package server
+
+import (
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/streaming"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Segments closer than this are regarded as continuous
+	minTimelineTolerance = 2.0 // seconds
+
+	// A stop event is usually recorded a little later than the last segment,
+	// because the watcher detects stalled streams periodically.
+	stopEventDelay = 60 * time.Second
+)
+
+func (m *Manager) getTimeline(streamId int64, from, to time.Time) (*common.Timeline, error) {
+	if !from.Before(to) {
+		return nil, common.ErrorInvalidTime
+	}
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+
+	timeline := common.NewTimeline(streamId, from, to)
+	ranges, err := getRecordedRanges(stream, from, to)
+	if err != nil {
+		return nil, err
+	}
+	timeline.Ranges = ranges
+
+	events, last, err := stream.GetEvents(from.Add(-stopEventDelay), to.Add(stopEventDelay))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		if e.Time >= from.Unix() && e.Time <= to.Unix() {
+			timeline.Events = append(timeline.Events, e)
+		}
+	}
+
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	timeline.Gaps = getTimelineGaps(ranges, from.Unix(), end.Unix(), events, last)
+
+	annotations, err := stream.GetAnnotations(&common.AnnotationFilter{From: from.Unix(), To: to.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	timeline.Annotations = annotations
+
+	return timeline, nil
+}
+
+// getRecordedRanges merges indexed segments into continuous ranges
+func getRecordedRanges(stream *streaming.Stream, from, to time.Time) ([]*common.TimeRange, error) {
+	segments := make([]*common.Segment, 0)
+	for _, date := range common.GetDatesBetween(from, to) {
+		list, err := stream.GetSegments(date)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, list...)
+	}
+
+	// Segment time is the time when the segment was closed
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].UnixTime < segments[j].UnixTime
+	})
+
+	ranges := make([]*common.TimeRange, 0)
+	var cur *common.TimeRange
+	for _, seg := range segments {
+		end := seg.UnixTime
+		start := end - int64(math.Ceil(seg.Duration))
+		if end < from.Unix() || start > to.Unix() {
+			continue
+		}
+		tolerance := int64(math.Max(minTimelineTolerance, math.Ceil(seg.Duration*1.5)))
+		if cur != nil && start-cur.To <= tolerance {
+			if end > cur.To {
+				cur.To = end
+			}
+			continue
+		}
+		cur = &common.TimeRange{From: start, To: end}
+		ranges = append(ranges, cur)
+	}
+
+	for _, r := range ranges {
+		if r.From < from.Unix() {
+			r.From = from.Unix()
+		}
+		if r.To > to.Unix() {
+			r.To = to.Unix()
+		}
+	}
+	return ranges, nil
+}
+
+func getTimelineGaps(ranges []*common.TimeRange, from, to int64, events []*common.StreamEvent, last *common.StreamEvent) []*common.TimelineGap {
+	gaps := make([]*common.TimelineGap, 0)
+	cursor := from
+	for _, r := range ranges {
+		if r.From > cursor {
+			gaps = append(gaps, &common.TimelineGap{From: cursor, To: r.From})
+		}
+		if r.To > cursor {
+			cursor = r.To
+		}
+	}
+	if cursor < to {
+		gaps = append(gaps, &common.TimelineGap{From: cursor, To: to})
+	}
+
+	for _, gap := range gaps {
+		gap.Reason = getGapReason(gap, events, last)
+	}
+	return gaps
+}
+
+// getGapReason finds out why nothing was recorded during the gap
+func getGapReason(gap *common.TimelineGap, events []*common.StreamEvent, last *common.StreamEvent) string {
+	before := last // the last event before the gap
+	disabled := before != nil && before.Reason == common.ReasonDisabled
+	for _, e := range events {
+		if e.Time > gap.From {
+			break
+		}
+		before = e
+		if e.Reason == common.ReasonDisabled {
+			disabled = true
+		}
+		if e.Reason == common.ReasonEnabled {
+			disabled = false
+		}
+	}
+	if disabled {
+		return common.ReasonDisabled
+	}
+
+	// Stream state changed during the gap
+	limit := gap.From + int64(stopEventDelay.Seconds())
+	for _, e := range events {
+		if e.Time <= gap.From {
+			continue
+		}
+		if e.Time > gap.To || e.Time > limit {
+			break
+		}
+		if isStopReason(e.Reason) {
+			return e.Reason
+		}
+	}
+
+	// Stream had already been stopped when the gap began
+	if before != nil && before.Status != common.Started && isStopReason(before.Reason) {
+		return before.Reason
+	}
+
+	return common.ReasonUnknown
+}
+
+func isStopReason(reason string) bool {
+	switch reason {
+	case common.ReasonStopped, common.ReasonFfmpegExited, common.ReasonStalled, common.ReasonFailed, common.ReasonSchedule, common.ReasonDisabled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	tokenPrefix = "rst_"
+
+	// Last-used time of tokens is written at most once in
+	tokenUsageInterval = time.Minute
+)
+
+// addToken issues an API token and returns the token which is shown only once
+func (m *Manager) addToken(t *common.ApiToken) (string, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if len(t.Name) < 1 || len(t.Name) > 64 || len(t.Scopes) < 1 {
+		return "", common.ErrorInvalidToken
+	}
+	for _, scope := range t.Scopes {
+		if !isValidScope(scope) {
+			return "", common.ErrorInvalidToken
+		}
+	}
+	now := time.Now().Unix()
+	if t.Expires != 0 && t.Expires <= now {
+		return "", common.ErrorInvalidToken
+	}
+	if t.Streams == nil {
+		t.Streams = make([]int64, 0)
+	}
+	for _, id := range t.Streams {
+		if m.getStreamById(id) == nil {
+			return "", common.ErrorStreamNotFound
+		}
+	}
+
+	secret, err := newToken(32)
+	if err != nil {
+		return "", err
+	}
+	token := tokenPrefix + secret
+	t.Created = now
+	t.LastUsed = 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.TokenBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		t.Id = int64(id)
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return b.Put(sessionKey(token), data)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func isValidScope(scope string) bool {
+	for _, s := range common.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func getTokens() ([]*common.ApiToken, error) {
+	tokens := make([]*common.ApiToken, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(common.TokenBucket).ForEach(func(k, v []byte) error {
+			var t common.ApiToken
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			tokens = append(tokens, &t)
+			return nil
+		})
+	})
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Id < tokens[j].Id
+	})
+	return tokens, err
+}
+
+// deleteToken revokes the token
+func deleteToken(id int64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.TokenBucket)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var t common.ApiToken
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			if t.Id == id {
+				return b.Delete(k)
+			}
+		}
+		return common.ErrorTokenNotFound
+	})
+}
+
+// getValidToken returns the token which hasn't expired and records when it was used
+func getValidToken(token string) (*common.ApiToken, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, common.ErrorUnauthorized
+	}
+	key := sessionKey(token)
+	var t *common.ApiToken
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(common.TokenBucket).Get(key)
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if t == nil || (t.Expires != 0 && t.Expires < now.Unix()) {
+		return nil, common.ErrorUnauthorized
+	}
+
+	if now.Sub(time.Unix(t.LastUsed, 0)) < tokenUsageInterval {
+		return t, nil
+	}
+	t.LastUsed = now.Unix()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.TokenBucket)
+		if b.Get(key) == nil { // revoked meanwhile
+			return common.ErrorUnauthorized
+		}
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/devplayg/hippo"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func isValidTranscodePolicy(policy *common.TranscodePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.AfterDays < 0 || policy.Height < 0 || policy.BitrateKbps < 0 {
+		return common.ErrorInvalidPolicy
+	}
+	if !policy.IsEnabled() {
+		return nil
+	}
+	if policy.Codec != common.CodecH264 && policy.Codec != common.CodecH265 {
+		return common.ErrorInvalidPolicy
+	}
+	return nil
+}
+
+// addTranscodeJobs queues jobs re-encoding archived days older than the policy of each stream
+func (m *Manager) addTranscodeJobs() error {
+	busy, err := m.getBusyDays()
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]*common.Job, 0)
+	for _, stream := range m.getStreams() {
+		if !stream.Transcode.IsEnabled() {
+			continue
+		}
+		t := time.Now().In(common.Loc).AddDate(0, 0, -stream.Transcode.AfterDays).Format(common.DateFormat)
+		days, err := m.getArchivedDays(stream)
+		if err != nil {
+			return err
+		}
+		for _, d := range days {
+			if d.date >= t || busy[m.getRecordKey(stream.Id, d.date)] {
+				continue
+			}
+			day, err := m.getDayArchive(stream.Id, d.date)
+			if err != nil {
+				return err
+			}
+			if day == nil || day.Transcoded > 0 {
+				continue
+			}
+			from, err := time.ParseInLocation(common.DateFormat, d.date, common.Loc)
+			if err != nil {
+				continue
+			}
+			jobs = append(jobs, common.NewJob(common.JobTranscode, stream.Id, from, from.AddDate(0, 0, 1), m.server.config.Archive.MaxAttempts))
+		}
+	}
+	if len(jobs) < 1 {
+		return nil
+	}
+	return m.jobs.add(jobs...)
+}
+
+// runTranscodeJob re-encodes segments of the archived day and swaps them.
+// Re-encoded segments are sent with new names, and then the playlist is replaced; old segments are deleted at last.
+func (m *Manager) runTranscodeJob(ctx context.Context, job *common.Job) error {
+	stream := m.getStreamById(job.StreamId)
+	if stream == nil {
+		return common.ErrorStreamNotFound
+	}
+	if !stream.Transcode.IsEnabled() {
+		return common.ErrorInvalidPolicy
+	}
+	policy := *stream.Transcode
+	date := time.Unix(job.From, 0).In(common.Loc).Format(common.DateFormat)
+	day, err := m.getDayArchive(job.StreamId, date)
+	if err != nil {
+		return err
+	}
+	if day == nil || day.Transcoded > 0 {
+		// Deleted by retention or quota, or transcoded already
+		return nil
+	}
+
+	recordKey := m.getRecordKey(job.StreamId, date)
+	backend, err := m.getBackend(job.StreamId, date)
+	if err != nil {
+		return err
+	}
+	if job.Stage == archiveStagePublished {
+		// Stopped after re-encoded segments had been published
+		return m.finishTranscodeJob(job, stream, backend, date)
+	}
+
+	playlist, err := m.readArchivedPlaylist(backend, recordKey)
+	if err != nil {
+		return err
+	}
+	if len(playlist.Segments) < 1 {
+		return common.ErrorNoVideos
+	}
+
+	workDir := filepath.Join(m.server.config.Storage.LiveDir, ".transcode", strconv.FormatInt(job.StreamId, 10), date)
+	if err := os.RemoveAll(workDir); err != nil {
+		return err
+	}
+	if err := hippo.EnsureDir(workDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	job.Result = &common.JobResult{InputFiles: len(playlist.Segments)}
+	next := getNextMediaNumber(playlist)
+	oldNames := make([]string, 0, len(playlist.Segments))
+	newNames := make([]string, 0, len(playlist.Segments))
+	for i, seg := range playlist.Segments {
+		name := filepath.Base(seg.URI)
+		inputPath := filepath.Join(workDir, name)
+		if err := copyArchivedVideo(backend, storage.Key(recordKey, name), inputPath); err != nil {
+			return err
+		}
+		if f, err := os.Stat(inputPath); err == nil {
+			job.Result.InputBytes += f.Size()
+		}
+
+		newName := fmt.Sprintf("%s%d%s", common.VideoFilePrefix, next+i, common.VideoFileExt)
+		outputPath := filepath.Join(workDir, newName)
+		output, err := TranscodeVideo(ctx, inputPath, outputPath, &policy)
+		if err != nil {
+			job.Result.Output = getOutputTail(output)
+			return err
+		}
+		duration, err := verifyTranscodedVideo(ctx, outputPath, seg.Duration)
+		if err != nil {
+			return err
+		}
+		f, err := os.Stat(outputPath)
+		if err != nil {
+			return err
+		}
+		job.Result.OutputFiles++
+		job.Result.OutputBytes += f.Size()
+		job.Result.SourceDuration += seg.Duration
+		job.Result.OutputDuration += duration
+
+		if err := os.Remove(inputPath); err != nil {
+			return err
+		}
+		oldNames = append(oldNames, name)
+		newNames = append(newNames, newName)
+		seg.URI = newName
+	}
+
+	if err := writePlaylist(filepath.Join(workDir, common.LiveM3u8FileName), playlist); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	published := append([]string{}, newNames...)
+	if err := m.writeKeyframeIndex(backend, recordKey, workDir, playlist.Segments, oldNames); err != nil {
+		log.WithFields(log.Fields{
+			"streamId": job.StreamId,
+			"date":     date,
+		}).Error(fmt.Errorf("failed to index keyframes: %w", err))
+	} else {
+		published = append(published, common.KeyframeFileName)
+	}
+	if err := m.writeArchiveManifest(job.StreamId, date, workDir, append(newNames, common.LiveM3u8FileName)); err != nil {
+		return err
+	}
+	if err := m.publishArchivedVideos(backend, recordKey, workDir, append(published, common.LiveM3u8FileName)); err != nil {
+		return err
+	}
+	job.Stage = archiveStagePublished
+	if err := m.jobs.update(job); err != nil {
+		return err
+	}
+
+	return m.finishTranscodeJob(job, stream, backend, date)
+}
+
+// finishTranscodeJob deletes old segments which the published playlist doesn't refer to, and marks the day as transcoded
+func (m *Manager) finishTranscodeJob(job *common.Job, stream *streaming.Stream, backend storage.Backend, date string) error {
+	recordKey := m.getRecordKey(job.StreamId, date)
+	playlist, err := m.readArchivedPlaylist(backend, recordKey)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, seg := range playlist.Segments {
+		current[filepath.Base(seg.URI)] = true
+	}
+	list, err := backend.List(recordKey + "/")
+	if err != nil {
+		return err
+	}
+	oldNames := make([]string, 0)
+	for _, obj := range list {
+		name := path.Base(obj.Key)
+		if !strings.HasSuffix(name, common.VideoFileExt) || current[name] {
+			continue
+		}
+		if err := backend.Delete(obj.Key); err != nil && err != storage.ErrNotFound {
+			log.WithFields(log.Fields{
+				"key": obj.Key,
+			}).Error(err)
+			continue
+		}
+		oldNames = append(oldNames, name)
+	}
+	if err := m.deleteArchiveManifestEntries(stream, date, oldNames); err != nil {
+		log.Error(err)
+	}
+
+	size, err := storage.GetSize(backend, recordKey+"/")
+	if err != nil {
+		return err
+	}
+	if err := m.writeVideoArchivingHistory(job.StreamId, date, size); err != nil {
+		return err
+	}
+	err = m.updateDayArchive(job.StreamId, date, func(day *common.DayArchive) {
+		day.Transcoded = time.Now().Unix()
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"streamId":    job.StreamId,
+		"date":        date,
+		"codec":       stream.Transcode.Codec,
+		"oldSegments": len(oldNames),
+	}).Info("[manager] archived videos have been transcoded")
+	return nil
+}
+
+// verifyTranscodedVideo compares the duration of the re-encoded segment with the original one, and returns the measured duration
+func verifyTranscodedVideo(ctx context.Context, path string, duration float64) (float64, error) {
+	d, err := GetVideoDuration(ctx, path, false)
+	if err != nil {
+		return 0, err
+	}
+	if math.Abs(d-duration) > math.Max(archiveDurationTolerance, duration*0.01) {
+		return 0, fmt.Errorf("duration mismatch: %s (%.3f != %.3f)", filepath.Base(path), d, duration)
+	}
+	return d, nil
+}
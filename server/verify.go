@@ -0,0 +1,301 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"github.com/devplayg/rtsp-stream/storage"
+	"github.com/devplayg/rtsp-stream/streaming"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type hashRecord struct {
+	size int64
+	hash string
+}
+
+func (m *Manager) verifyVideos(streamId int64, date string) (*common.VerificationReport, error) {
+	if _, err := time.ParseInLocation(common.DateFormat, date, common.Loc); err != nil {
+		return nil, common.ErrorInvalidDate
+	}
+
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return nil, common.ErrorStreamNotFound
+	}
+
+	manifest, err := m.getArchiveManifest(stream, date)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest) > 0 {
+		return m.verifyArchivedVideos(stream, date, manifest)
+	}
+	return m.verifyLiveVideos(stream, date)
+}
+
+func (m *Manager) verifyLiveVideos(stream *streaming.Stream, date string) (*common.VerificationReport, error) {
+	report := common.NewVerificationReport(stream.Id, date, common.VerifyLive)
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(stream.Id, 10))
+
+	segments, err := stream.GetSegments(date)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]*hashRecord)
+	for _, seg := range segments {
+		records[seg.URI] = &hashRecord{size: seg.Size, hash: seg.Hash}
+	}
+
+	files, err := common.ReadVideoFilesOnDateInDir(liveDir, date, common.VideoFileExt)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// The segment being written by ffmpeg hasn't been indexed yet
+	recent := time.Now().Add(-10 * time.Second)
+	candidates := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if f.ModTime().After(recent) {
+			continue
+		}
+		candidates = append(candidates, f)
+	}
+
+	sizes := make(map[string]int64)
+	for _, f := range candidates {
+		sizes[f.Name()] = f.Size()
+	}
+	verifyFiles(report, records, sizes, func(name string) ([]byte, error) {
+		return streaming.GetHashFromFile(filepath.Join(liveDir, name))
+	})
+	return report, nil
+}
+
+func (m *Manager) verifyArchivedVideos(stream *streaming.Stream, date string, manifest []*common.TransmissionResult) (*common.VerificationReport, error) {
+	report := common.NewVerificationReport(stream.Id, date, common.VerifyArchive)
+	recordKey := m.getRecordKey(stream.Id, date)
+	backend, err := m.getBackend(stream.Id, date)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]*hashRecord)
+	for _, r := range manifest {
+		records[r.Name] = &hashRecord{size: r.Size, hash: hex.EncodeToString(r.Hash)}
+	}
+
+	list, err := backend.List(recordKey + "/")
+	if err != nil {
+		return nil, err
+	}
+	// Sizes of Stat are plaintext sizes if the storage is encrypted
+	sizes := make(map[string]int64)
+	for _, obj := range list {
+		name := path.Base(obj.Key)
+		if !isArchivedFile(name) {
+			continue
+		}
+		info, err := backend.Stat(obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		sizes[name] = info.Size
+	}
+
+	verifyFiles(report, records, sizes, func(name string) ([]byte, error) {
+		object, err := backend.Get(storage.Key(recordKey, name))
+		if err != nil {
+			return nil, err
+		}
+		defer object.Close()
+		return streaming.GetHash(object)
+	})
+	return report, nil
+}
+
+// verifyLiveFilesToArchive checks live videos against the hashes recorded when they were indexed.
+// Archived videos are hashed again, so altered live videos must not be archived.
+func verifyLiveFilesToArchive(streamId int64, date, liveDir string, liveFiles []os.FileInfo, segmentMap map[string]*common.Segment) error {
+	records := make(map[string]*hashRecord)
+	files := make(map[string]int64)
+	for _, f := range liveFiles {
+		files[f.Name()] = f.Size()
+		if seg, ok := segmentMap[f.Name()]; ok {
+			records[f.Name()] = &hashRecord{size: seg.Size, hash: seg.Hash}
+		}
+	}
+
+	report := common.NewVerificationReport(streamId, date, common.VerifyLive)
+	verifyFiles(report, records, files, func(name string) ([]byte, error) {
+		return streaming.GetHashFromFile(filepath.Join(liveDir, name))
+	})
+	if len(report.Altered) > 0 {
+		return fmt.Errorf("%w: %s", common.ErrorAlteredVideos, strings.Join(report.Altered, ", "))
+	}
+	return nil
+}
+
+// verifyFiles compares files(name/size) with the records
+func verifyFiles(report *common.VerificationReport, records map[string]*hashRecord, files map[string]int64, getHash func(name string) ([]byte, error)) {
+	for name := range files {
+		if _, ok := records[name]; !ok {
+			report.Extra = append(report.Extra, name)
+		}
+	}
+
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report.Total = len(records)
+	for _, name := range names {
+		r := records[name]
+		size, ok := files[name]
+		if !ok {
+			report.Missing = append(report.Missing, name)
+			continue
+		}
+		if len(r.hash) < 1 {
+			report.Unhashed++
+			continue
+		}
+		if size != r.size {
+			report.Altered = append(report.Altered, name)
+			continue
+		}
+		hash, err := getHash(name)
+		if err != nil {
+			log.Error(err)
+			report.Altered = append(report.Altered, name)
+			continue
+		}
+		if hex.EncodeToString(hash) != r.hash {
+			report.Altered = append(report.Altered, name)
+			continue
+		}
+		report.Verified++
+	}
+	sort.Strings(report.Extra)
+
+	report.Ok = len(report.Missing) == 0 && len(report.Altered) == 0 && len(report.Extra) == 0
+}
+
+func isArchivedFile(name string) bool {
+	return strings.HasSuffix(name, common.VideoFileExt) || name == common.LiveM3u8FileName
+}
+
+// writeArchiveManifest records the size and hash of the archived files of the date
+func (m *Manager) writeArchiveManifest(streamId int64, date, recordDir string, names []string) error {
+	stream := m.getStreamById(streamId)
+	if stream == nil {
+		return common.ErrorStreamNotFound
+	}
+
+	results := make([]*common.TransmissionResult, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(recordDir, name)
+		f, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		hash, err := streaming.GetHashFromFile(path)
+		if err != nil {
+			return err
+		}
+		seq, _ := streaming.GetVideoFileSeq(name)
+		result := common.NewTransmissionResult(streamId, seq, f.Size(), hash, date)
+		result.Name = name
+		results = append(results, result)
+	}
+
+	return stream.DB.Update(func(tx *bolt.Tx) error {
+		archive, err := tx.CreateBucketIfNotExists(common.ArchiveBucket)
+		if err != nil {
+			return err
+		}
+		b, err := archive.CreateBucketIfNotExists([]byte(date))
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(r.Name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Manager) getArchiveManifest(stream *streaming.Stream, date string) ([]*common.TransmissionResult, error) {
+	results := make([]*common.TransmissionResult, 0)
+	err := stream.DB.View(func(tx *bolt.Tx) error {
+		archive := tx.Bucket(common.ArchiveBucket)
+		if archive == nil {
+			return nil
+		}
+		b := archive.Bucket([]byte(date))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var r common.TransmissionResult
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			results = append(results, &r)
+			return nil
+		})
+	})
+	return results, err
+}
+
+func (m *Manager) deleteArchiveManifest(stream *streaming.Stream, date string) error {
+	if stream.DB == nil {
+		return nil
+	}
+	return stream.DB.Update(func(tx *bolt.Tx) error {
+		archive := tx.Bucket(common.ArchiveBucket)
+		if archive == nil || archive.Bucket([]byte(date)) == nil {
+			return nil
+		}
+		return archive.DeleteBucket([]byte(date))
+	})
+}
+
+func (m *Manager) deleteArchiveManifestEntries(stream *streaming.Stream, date string, names []string) error {
+	if stream.DB == nil {
+		return nil
+	}
+	return stream.DB.Update(func(tx *bolt.Tx) error {
+		archive := tx.Bucket(common.ArchiveBucket)
+		if archive == nil {
+			return nil
+		}
+		b := archive.Bucket([]byte(date))
+		if b == nil {
+			return nil
+		}
+		for _, name := range names {
+			if err := b.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"github.com/devplayg/rtsp-stream/common"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskWatchdog monitors free space and inodes of the live and record directories
+type diskWatchdog struct {
+	status *common.WatchdogStatus
+	sync.RWMutex
+}
+
+func (m *Manager) startDiskWatchdog() {
+	interval := time.Duration(m.server.config.Watchdog.Interval) * time.Second
+	log.WithFields(log.Fields{
+		"interval": interval.String(),
+		"warning":  m.server.config.Watchdog.Warning,
+		"critical": m.server.config.Watchdog.Critical,
+		"target":   m.server.config.Watchdog.Target,
+	}).Debug("[manager] disk watchdog has been started")
+	for {
+		status := m.checkDisks()
+		if status.Level == common.DiskCritical {
+			m.purgeOldestVideos(status)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-m.ctx.Done():
+			log.Debug("[manager] disk watchdog has been stopped")
+			return
+		}
+	}
+}
+
+// getWatchedDirs returns directories on the local disk
+func (m *Manager) getWatchedDirs() []string {
+	dirs := []string{m.server.config.Storage.LiveDir}
+	if !m.server.config.Storage.Remote {
+		dirs = append(dirs, m.server.config.Storage.RecordDir)
+	}
+	return dirs
+}
+
+// checkDisks updates the status of the watched directories
+func (m *Manager) checkDisks() *common.WatchdogStatus {
+	m.watchdog.RLock()
+	status := &common.WatchdogStatus{
+		Level: common.DiskOk,
+		Dirs:  make([]*common.DiskStatus, 0),
+	}
+	if m.watchdog.status != nil {
+		status.LastPurge = m.watchdog.status.LastPurge
+		status.Purged = m.watchdog.status.Purged
+	}
+	m.watchdog.RUnlock()
+
+	for _, dir := range m.getWatchedDirs() {
+		disk, err := getDiskStatus(dir)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"dir": dir,
+			}).Error(err)
+			status.Error = err.Error()
+			continue
+		}
+		m.setDiskLevel(disk)
+		if levelOrder(disk.Level) > levelOrder(status.Level) {
+			status.Level = disk.Level
+		}
+		status.Dirs = append(status.Dirs, disk)
+	}
+	status.Checked = time.Now().Unix()
+
+	m.watchdog.Lock()
+	prev := m.watchdog.status
+	m.watchdog.status = status
+	m.watchdog.Unlock()
+
+	if prev == nil || prev.Level != status.Level {
+		entry := log.WithFields(log.Fields{
+			"level": status.Level,
+		})
+		switch status.Level {
+		case common.DiskCritical:
+			entry.Error("[watchdog] free disk space is critical")
+		case common.DiskWarning:
+			entry.Warn("[watchdog] free disk space is low")
+		default:
+			entry.Info("[watchdog] free disk space is ok")
+		}
+	}
+	return status
+}
+
+func (m *Manager) setDiskLevel(disk *common.DiskStatus) {
+	disk.FreePercent = 100
+	if disk.TotalBytes > 0 {
+		disk.FreePercent = float64(disk.FreeBytes) * 100 / float64(disk.TotalBytes)
+	}
+	disk.FreeInodesPercent = 100
+	if disk.Inodes > 0 {
+		disk.FreeInodesPercent = float64(disk.FreeInodes) * 100 / float64(disk.Inodes)
+	}
+
+	free := disk.FreePercent
+	if disk.FreeInodesPercent < free {
+		free = disk.FreeInodesPercent
+	}
+	switch {
+	case free < m.server.config.Watchdog.Critical:
+		disk.Level = common.DiskCritical
+	case free < m.server.config.Watchdog.Warning:
+		disk.Level = common.DiskWarning
+	default:
+		disk.Level = common.DiskOk
+	}
+}
+
+func levelOrder(level string) int {
+	switch level {
+	case common.DiskCritical:
+		return 2
+	case common.DiskWarning:
+		return 1
+	}
+	return 0
+}
+
+func (m *Manager) getWatchdogStatus() *common.WatchdogStatus {
+	m.watchdog.RLock()
+	defer m.watchdog.RUnlock()
+	if m.watchdog.status == nil {
+		return &common.WatchdogStatus{Level: common.DiskOk, Dirs: make([]*common.DiskStatus, 0)}
+	}
+	return m.watchdog.status
+}
+
+// isAboveTarget returns true if all the watched directories reached the low-water mark
+func (m *Manager) isAboveTarget() bool {
+	for _, dir := range m.getWatchedDirs() {
+		disk, err := getDiskStatus(dir)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		m.setDiskLevel(disk)
+		if disk.FreePercent < m.server.config.Watchdog.Target || disk.FreeInodesPercent < m.server.config.Watchdog.Target {
+			return false
+		}
+	}
+	return true
+}
+
+// purgeOldestVideos deletes leftover live videos which have been archived and then
+// the oldest archived days on the local disk until the low-water mark is reached.
+// Protected days, today and days being archived are not purged.
+func (m *Manager) purgeOldestVideos(status *common.WatchdogStatus) {
+	purged := make([]string, 0)
+	defer func() {
+		m.watchdog.Lock()
+		m.watchdog.status.LastPurge = time.Now().Unix()
+		m.watchdog.status.Purged = purged
+		m.watchdog.Unlock()
+		log.WithFields(log.Fields{
+			"purged": len(purged),
+		}).Warn("[watchdog] oldest videos have been purged")
+	}()
+
+	archivedUntil, err := m.getArchivedUntil()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, stream := range m.getStreams() {
+		files := m.deleteLiveVideosBefore(stream.Id, archivedUntil(stream.Id))
+		purged = append(purged, files...)
+	}
+	if m.isAboveTarget() {
+		return
+	}
+
+	if m.server.config.Storage.Remote {
+		return
+	}
+	busy, err := m.getBusyDays()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	today := time.Now().In(common.Loc).Format(common.DateFormat)
+	days := make([]*archivedDay, 0)
+	for _, stream := range m.getStreams() {
+		list, err := m.getArchivedDays(stream)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, d := range list {
+			if d.date >= today || d.protected || d.tier == common.TierCold || busy[m.getRecordKey(d.stream.Id, d.date)] {
+				continue
+			}
+			days = append(days, d)
+		}
+	}
+	sort.SliceStable(days, func(i, j int) bool {
+		if days[i].date != days[j].date {
+			return days[i].date < days[j].date
+		}
+		return days[i].stream.Id < days[j].stream.Id
+	})
+
+	for _, d := range days {
+		if err := m.deleteArchivedDay(d.stream, d.date); err != nil {
+			log.Error(err)
+			continue
+		}
+		purged = append(purged, m.getRecordKey(d.stream.Id, d.date))
+		if m.isAboveTarget() {
+			return
+		}
+	}
+	log.Error("[watchdog] no more videos to purge")
+}
+
+// getArchivedUntil returns a function returning the time until which live videos of the stream have been archived
+func (m *Manager) getArchivedUntil() (func(streamId int64) time.Time, error) {
+	lastArchivingTime, err := m.getLastArchivingTime(time.Now().In(common.Loc))
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := m.jobs.list(func(job *common.Job) bool {
+		return job.Type == common.JobArchive && !job.IsFinished()
+	})
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[int64]time.Time)
+	for _, job := range jobs {
+		from := time.Unix(job.From, 0)
+		if t, ok := pending[job.StreamId]; !ok || from.Before(t) {
+			pending[job.StreamId] = from
+		}
+	}
+	return func(streamId int64) time.Time {
+		if t, ok := pending[streamId]; ok && t.Before(lastArchivingTime) {
+			return t
+		}
+		return lastArchivingTime
+	}, nil
+}
+
+// deleteLiveVideosBefore deletes live videos which have been archived
+func (m *Manager) deleteLiveVideosBefore(streamId int64, t time.Time) []string {
+	deleted := make([]string, 0)
+	liveDir := filepath.Join(m.server.config.Storage.LiveDir, strconv.FormatInt(streamId, 10))
+	files, err := ioutil.ReadDir(liveDir)
+	if err != nil {
+		return deleted
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !strings.HasSuffix(f.Name(), common.VideoFileExt) || !f.ModTime().Before(t) {
+			continue
+		}
+		path := filepath.Join(liveDir, f.Name())
+		if err := os.Remove(path); err != nil {
+			log.Error(err)
+			continue
+		}
+		deleted = append(deleted, path)
+	}
+	return deleted
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted objects consist of a header and chunks sealed by AES-256-GCM.
+//
+//	header: magic(4) | key id(4) | chunk size(4) | plaintext size(8) | nonce prefix(8)
+//	chunk:  ciphertext(up to chunk size) | tag(16)
+//
+// The nonce of a chunk is the nonce prefix followed by the chunk index, and the additional data is
+// the header followed by a flag of the last chunk, so chunks can't be reordered, truncated or moved to other objects.
+const (
+	encryptionMagic     = "RSE1"
+	encryptionHeaderLen = 28
+	encryptionChunkSize = 64 * 1024
+)
+
+var errInvalidEncryptedObject = errors.New("invalid encrypted object")
+
+type encryptionHeader struct {
+	keyId       uint32
+	chunkSize   int64
+	size        int64 // plaintext
+	noncePrefix [8]byte
+}
+
+func (h *encryptionHeader) encode() []byte {
+	buf := make([]byte, encryptionHeaderLen)
+	copy(buf, encryptionMagic)
+	binary.BigEndian.PutUint32(buf[4:], h.keyId)
+	binary.BigEndian.PutUint32(buf[8:], uint32(h.chunkSize))
+	binary.BigEndian.PutUint64(buf[12:], uint64(h.size))
+	copy(buf[20:], h.noncePrefix[:])
+	return buf
+}
+
+// parseEncryptionHeader returns nil if the data isn't encrypted
+func parseEncryptionHeader(buf []byte) *encryptionHeader {
+	if len(buf) < encryptionHeaderLen || string(buf[:4]) != encryptionMagic {
+		return nil
+	}
+	h := &encryptionHeader{
+		keyId:     binary.BigEndian.Uint32(buf[4:]),
+		chunkSize: int64(binary.BigEndian.Uint32(buf[8:])),
+		size:      int64(binary.BigEndian.Uint64(buf[12:])),
+	}
+	copy(h.noncePrefix[:], buf[20:28])
+	if h.chunkSize < 1 || h.size < 0 {
+		return nil
+	}
+	return h
+}
+
+func (h *encryptionHeader) chunks() int64 {
+	if h.size == 0 {
+		return 1
+	}
+	return (h.size + h.chunkSize - 1) / h.chunkSize
+}
+
+func (h *encryptionHeader) nonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, h.noncePrefix[:])
+	binary.BigEndian.PutUint32(nonce[8:], uint32(index))
+	return nonce
+}
+
+func (h *encryptionHeader) additionalData(index int64) []byte {
+	aad := h.encode()
+	if index == h.chunks()-1 {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedStorage encrypts objects of the backend. Objects which were stored before encryption was enabled are read as they are.
+// Sizes of List are stored sizes, and sizes of Stat are plaintext sizes.
+type EncryptedStorage struct {
+	backend Backend
+	keys    KeyProvider
+}
+
+func NewEncryptedStorage(backend Backend, keys KeyProvider) *EncryptedStorage {
+	return &EncryptedStorage{backend: backend, keys: keys}
+}
+
+func (s *EncryptedStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		return fmt.Errorf("size is required to encrypt: %s", key)
+	}
+	keyId, masterKey, err := s.keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return err
+	}
+	h := &encryptionHeader{keyId: keyId, chunkSize: encryptionChunkSize, size: size}
+	if _, err := rand.Read(h.noncePrefix[:]); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encrypt(pw, r, h, aead))
+	}()
+	err = s.backend.Put(key, pr, encryptionHeaderLen+size+h.chunks()*int64(aead.Overhead()), contentType)
+	pr.CloseWithError(err)
+	return err
+}
+
+func encrypt(w io.Writer, r io.Reader, h *encryptionHeader, aead cipher.AEAD) error {
+	if _, err := w.Write(h.encode()); err != nil {
+		return err
+	}
+	buf := make([]byte, h.chunkSize)
+	remaining := h.size
+	for i := int64(0); i < h.chunks(); i++ {
+		n := h.chunkSize
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+		if _, err := w.Write(aead.Seal(nil, h.nonce(i), buf[:n], h.additionalData(i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EncryptedStorage) Get(key string) (ReadSeekCloser, error) {
+	object, err := s.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, encryptionHeaderLen)
+	n, err := io.ReadFull(object, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		object.Close()
+		return nil, err
+	}
+	h := parseEncryptionHeader(buf[:n])
+	if h == nil {
+		// Not encrypted
+		if _, err := object.Seek(0, io.SeekStart); err != nil {
+			object.Close()
+			return nil, err
+		}
+		return object, nil
+	}
+
+	masterKey, err := s.keys.Key(h.keyId)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	return &decryptReader{object: object, header: h, aead: aead, chunk: -1}, nil
+}
+
+func (s *EncryptedStorage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	object, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Seek(offset, io.SeekStart); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(object, length), object}, nil
+}
+
+func (s *EncryptedStorage) List(prefix string) ([]*ObjectInfo, error) {
+	return s.backend.List(prefix)
+}
+
+func (s *EncryptedStorage) Delete(key string) error {
+	return s.backend.Delete(key)
+}
+
+func (s *EncryptedStorage) Stat(key string) (*ObjectInfo, error) {
+	info, err := s.backend.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size < encryptionHeaderLen {
+		return info, nil
+	}
+	r, err := s.backend.GetRange(key, 0, encryptionHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf := make([]byte, encryptionHeaderLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if h := parseEncryptionHeader(buf); h != nil {
+		plain := *info
+		plain.Size = h.size
+		return &plain, nil
+	}
+	return info, nil
+}
+
+// decryptReader decrypts the chunk of the position on demand, so seeking costs a chunk at most
+type decryptReader struct {
+	object ReadSeekCloser
+	header *encryptionHeader
+	aead   cipher.AEAD
+	pos    int64
+	chunk  int64 // index of the decrypted chunk in buf
+	buf    []byte
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.pos >= r.header.size {
+		return 0, io.EOF
+	}
+	index := r.pos / r.header.chunkSize
+	if index != r.chunk {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.pos-index*r.header.chunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) load(index int64) error {
+	overhead := int64(r.aead.Overhead())
+	offset := encryptionHeaderLen + index*(r.header.chunkSize+overhead)
+	if _, err := r.object.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	n := r.header.chunkSize
+	if rest := r.header.size - index*r.header.chunkSize; rest < n {
+		n = rest
+	}
+	sealed := make([]byte, n+overhead)
+	if _, err := io.ReadFull(r.object, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Truncated; io.EOF would end reading silently
+			return errInvalidEncryptedObject
+		}
+		return err
+	}
+	plain, err := r.aead.Open(sealed[:0], r.header.nonce(index), sealed, r.header.additionalData(index))
+	if err != nil {
+		return errInvalidEncryptedObject
+	}
+	r.buf = plain
+	r.chunk = index
+	return nil
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.header.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.object.Close()
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
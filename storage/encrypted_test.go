@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestEncryptedStorage(t *testing.T) (*EncryptedStorage, *LocalStorage, string) {
+	dir := newTempDir(t)
+	backend, err := NewLocalStorage(filepath.Join(dir, "records"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewFileKeyProvider(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptedStorage(backend, keys), backend, dir
+}
+
+func newRandomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func TestEncryptedStorageRoundTrip(t *testing.T) {
+	s, backend, dir := newTestEncryptedStorage(t)
+	defer os.RemoveAll(dir)
+
+	sizes := []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 100}
+	for _, size := range sizes {
+		data := newRandomData(size)
+		put(t, s, "1/20200301/media.ts", data)
+		if got := get(t, s, "1/20200301/media.ts"); !bytes.Equal(got, data) {
+			t.Fatalf("size %d: decrypted data differs", size)
+		}
+
+		// Stored objects are encrypted and Stat returns plaintext sizes
+		stored := get(t, backend, "1/20200301/media.ts")
+		if size >= 16 && bytes.Contains(stored, data) {
+			t.Fatalf("size %d: plaintext is stored", size)
+		}
+		info, err := s.Stat("1/20200301/media.ts")
+		if err != nil || info.Size != int64(size) {
+			t.Fatalf("size %d: unexpected size %v (%v)", size, info, err)
+		}
+	}
+
+	// Ranges across chunk boundaries
+	data := newRandomData(3*encryptionChunkSize + 100)
+	put(t, s, "1/20200301/media.ts", data)
+	ranges := [][2]int64{
+		{0, 10},
+		{encryptionChunkSize - 5, 10},
+		{encryptionChunkSize, encryptionChunkSize},
+		{encryptionChunkSize - 1, 2*encryptionChunkSize + 2},
+		{3 * encryptionChunkSize, 100},
+		{3*encryptionChunkSize + 90, 1000}, // beyond the end
+	}
+	for _, rng := range ranges {
+		r, err := s.GetRange("1/20200301/media.ts", rng[0], rng[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		end := rng[0] + rng[1]
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if err != nil || !bytes.Equal(got, data[rng[0]:end]) {
+			t.Errorf("range %v: decrypted data differs (%v)", rng, err)
+		}
+	}
+
+	// Objects stored before encryption are read as they are
+	put(t, backend, "1/20200301/plain.ts", []byte("plain"))
+	if got := get(t, s, "1/20200301/plain.ts"); string(got) != "plain" {
+		t.Errorf("expected plain object, got %q", got)
+	}
+}
+
+func TestEncryptedStorageKeyRotation(t *testing.T) {
+	s, _, dir := newTestEncryptedStorage(t)
+	defer os.RemoveAll(dir)
+
+	old := newRandomData(1000)
+	put(t, s, "1/20200301/old.ts", old)
+	if _, err := s.keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "1/20200301/new.ts", newRandomData(2000))
+	if got := get(t, s, "1/20200301/old.ts"); !bytes.Equal(got, old) {
+		t.Error("object encrypted with the old key differs")
+	}
+}
+
+func TestEncryptedStorageTampering(t *testing.T) {
+	s, backend, dir := newTestEncryptedStorage(t)
+	defer os.RemoveAll(dir)
+
+	data := newRandomData(2*encryptionChunkSize + 100)
+	put(t, s, "1/20200301/media.ts", data)
+	stored := get(t, backend, "1/20200301/media.ts")
+	chunkLen := encryptionChunkSize + 16
+
+	tests := map[string]func([]byte) []byte{
+		"flipped bit": func(b []byte) []byte {
+			b[encryptionHeaderLen+chunkLen+10] ^= 1
+			return b
+		},
+		"swapped chunks": func(b []byte) []byte {
+			first := append([]byte{}, b[encryptionHeaderLen:encryptionHeaderLen+chunkLen]...)
+			copy(b[encryptionHeaderLen:], b[encryptionHeaderLen+chunkLen:encryptionHeaderLen+2*chunkLen])
+			copy(b[encryptionHeaderLen+chunkLen:], first)
+			return b
+		},
+		"truncated": func(b []byte) []byte {
+			return b[:encryptionHeaderLen+2*chunkLen]
+		},
+		"altered size": func(b []byte) []byte {
+			b[19]-- // last byte of the plaintext size
+			return b
+		},
+	}
+	for name, tamper := range tests {
+		altered := tamper(append([]byte{}, stored...))
+		put(t, backend, "1/20200301/altered.ts", altered)
+
+		r, err := s.Get("1/20200301/altered.ts")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		_, err = io.Copy(ioutil.Discard, r)
+		r.Close()
+		if err == nil {
+			t.Errorf("%s: tampering is not detected", name)
+		}
+	}
+
+	// Chunks can't be moved to other objects
+	put(t, s, "1/20200301/other.ts", newRandomData(2*encryptionChunkSize+100))
+	other := get(t, backend, "1/20200301/other.ts")
+	altered := append([]byte{}, stored...)
+	copy(altered[encryptionHeaderLen:], other[encryptionHeaderLen:encryptionHeaderLen+chunkLen])
+	put(t, backend, "1/20200301/altered.ts", altered)
+	r, err := s.Get("1/20200301/altered.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(ioutil.Discard, r); err != errInvalidEncryptedObject {
+		t.Errorf("expected errInvalidEncryptedObject, got %v", err)
+	}
+}
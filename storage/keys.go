@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const keySize = 32 // AES-256
+
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// KeyProvider provides keys for EncryptedStorage. Objects are encrypted with the current key,
+// and old keys are kept to decrypt objects encrypted before rotation.
+type KeyProvider interface {
+	CurrentKey() (uint32, []byte, error)
+	Key(id uint32) ([]byte, error)
+	Rotate() (uint32, error)
+}
+
+// FileKeyProvider keeps master keys as files named "{id}.key" in the directory; the key with the highest id is current
+type FileKeyProvider struct {
+	dir     string
+	keys    map[uint32][]byte
+	current uint32
+	sync.RWMutex
+}
+
+// NewFileKeyProvider loads keys in the directory; the first key is generated if there is no key
+func NewFileKeyProvider(dir string) (*FileKeyProvider, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	p := &FileKeyProvider{dir: dir}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	if len(p.keys) < 1 {
+		if _, err := p.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *FileKeyProvider) load() error {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+	keys := make(map[uint32][]byte)
+	var current uint32
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !strings.HasSuffix(f.Name(), ".key") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".key"), 10, 32)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(p.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != keySize {
+			return fmt.Errorf("invalid encryption key: %s", f.Name())
+		}
+		keys[uint32(id)] = key
+		if uint32(id) > current {
+			current = uint32(id)
+		}
+	}
+
+	p.Lock()
+	p.keys = keys
+	p.current = current
+	p.Unlock()
+	return nil
+}
+
+func (p *FileKeyProvider) CurrentKey() (uint32, []byte, error) {
+	p.RLock()
+	defer p.RUnlock()
+	key, ok := p.keys[p.current]
+	if !ok {
+		return 0, nil, ErrKeyNotFound
+	}
+	return p.current, key, nil
+}
+
+// Key returns the key of the id; keys added by other processes are loaded again
+func (p *FileKeyProvider) Key(id uint32) ([]byte, error) {
+	p.RLock()
+	key, ok := p.keys[id]
+	p.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Rotate generates a new key which becomes current
+func (p *FileKeyProvider) Rotate() (uint32, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	id := p.current + 1
+	path := filepath.Join(p.dir, fmt.Sprintf("%d.key", id))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key)); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if p.keys == nil {
+		p.keys = make(map[uint32][]byte)
+	}
+	p.keys[id] = key
+	p.current = id
+	return id, nil
+}
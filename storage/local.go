@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage stores objects as files under the root directory
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	if len(root) < 1 {
+		return nil, fmt.Errorf("invalid root directory")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{root: root}, nil
+}
+
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(strings.TrimPrefix(key, "/")))
+}
+
+// Put writes the object into a temporary file first so that readers never see partial objects
+func (s *LocalStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("size mismatch: expected %d, written %d", size, n)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *LocalStorage) Get(key string) (ReadSeekCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalStorage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sectionReadCloser{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+// List walks only the directory of the prefix; "a/b/" walks "a/b" and "a/b/c" walks "a/b"
+func (s *LocalStorage) List(prefix string) ([]*ObjectInfo, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	dir := s.path(prefix)
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		dir = filepath.Dir(dir)
+	}
+
+	list := make([]*ObjectInfo, 0)
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		list = append(list, newLocalObjectInfo(key, f))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	return list, err
+}
+
+// Delete removes the object and its parent directories if they become empty
+func (s *LocalStorage) Delete(key string) error {
+	path := s.path(key)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.root) && strings.HasPrefix(dir, filepath.Clean(s.root)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	f, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !f.Mode().IsRegular() {
+		return nil, ErrNotFound
+	}
+	return newLocalObjectInfo(key, f), nil
+}
+
+func newLocalObjectInfo(key string, f os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:     key,
+		Size:    f.Size(),
+		ModTime: f.ModTime(),
+		ETag:    fmt.Sprintf("%x-%x", f.ModTime().UnixNano(), f.Size()),
+	}
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/minio/minio-go"
+	"io"
+	"strings"
+)
+
+// S3Storage stores objects in a bucket of S3 compatible object storage like MinIO
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3Storage(address, accessKey, secretKey, bucket string, useSSL bool) (*S3Storage, error) {
+	if len(bucket) < 1 {
+		return nil, fmt.Errorf("invalid bucket")
+	}
+	client, err := minio.New(address, accessKey, secretKey, useSSL)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3StorageWithClient(client, bucket)
+}
+
+// NewS3StorageWithClient creates the bucket if it does not exist
+func NewS3StorageWithClient(client *minio.Client, bucket string) (*S3Storage, error) {
+	exists, err := client.BucketExists(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(bucket, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &S3Storage{client: client, bucket: bucket}, nil
+}
+
+func (s *S3Storage) Put(key string, r io.Reader, size int64, contentType string) error {
+	if len(contentType) < 1 {
+		contentType = "application/octet-stream"
+	}
+	_, err := s.client.PutObject(s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3Storage) Get(key string) (ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	// GetObject doesn't send a request until the object is read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, toError(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+
+	// Stat() of Client.GetObject removes the range, so the range request is sent by Core
+	obj, _, err := minio.Core{Client: s.client}.GetObject(s.bucket, key, opts)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) List(prefix string) ([]*ObjectInfo, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	list := make([]*ObjectInfo, 0)
+	for obj := range s.client.ListObjectsV2(s.bucket, prefix, true, doneCh) {
+		if obj.Err != nil {
+			return nil, toError(obj.Err)
+		}
+		list = append(list, newS3ObjectInfo(obj))
+	}
+	return list, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	return toError(s.client.RemoveObject(s.bucket, key))
+}
+
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	obj, err := s.client.StatObject(s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, toError(err)
+	}
+	return newS3ObjectInfo(obj), nil
+}
+
+func newS3ObjectInfo(obj minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:     obj.Key,
+		Size:    obj.Size,
+		ModTime: obj.LastModified,
+		ETag:    strings.Trim(obj.ETag, `"`),
+	}
+}
+
+func toError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/minio/minio-go"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeS3Object struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+}
+
+// fakeS3 serves a minimal subset of the S3 API with path-style requests
+type fakeS3 struct {
+	buckets map[string]map[string]*fakeS3Object
+	sync.Mutex
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{buckets: make(map[string]map[string]*fakeS3Object)}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucketName, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucketName, key = path[:i], path[i+1:]
+	}
+	bucket, exists := s.buckets[bucketName]
+
+	if len(key) < 1 {
+		switch {
+		case r.Method == http.MethodGet && isQuery(r, "location"):
+			writeXml(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+			}{})
+		case r.Method == http.MethodHead && !exists:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut:
+			s.buckets[bucketName] = make(map[string]*fakeS3Object)
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && exists && r.URL.Query().Get("list-type") == "2":
+			s.list(w, r, bucket)
+		default:
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		}
+		return
+	}
+	if !exists {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	obj, found := bucket[key]
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		sum := md5.Sum(data)
+		obj = &fakeS3Object{data: data, modTime: time.Now().UTC().Truncate(time.Second), etag: hex.EncodeToString(sum[:])}
+		bucket[key] = obj
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		if !found {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, r *http.Request, bucket map[string]*fakeS3Object) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Prefix: r.URL.Query().Get("prefix")}
+
+	keys := make([]string, 0)
+	for key := range bucket {
+		if strings.HasPrefix(key, result.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj := bucket[key]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: obj.modTime.Format(time.RFC3339),
+			ETag:         `"` + obj.etag + `"`,
+			Size:         int64(len(obj.data)),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXml(w, http.StatusOK, result)
+}
+
+func isQuery(r *http.Request, name string) bool {
+	_, ok := r.URL.Query()[name]
+	return ok
+}
+
+func writeXml(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	writeXml(w, status, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
+
+func newTestS3Storage(t *testing.T) (*S3Storage, *httptest.Server) {
+	server := httptest.NewServer(newFakeS3())
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signature V2 doesn't use streaming payloads which the fake server can't read
+	client, err := minio.NewV2(u.Host, "access", "secret", false)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	b, err := NewS3StorageWithClient(client, "rtsp-stream")
+	if err != nil {
+		server.Close()
+		t.Fatal(fmt.Errorf("failed to create bucket: %w", err))
+	}
+	return b, server
+}
+
+func TestS3Storage(t *testing.T) {
+	b, server := newTestS3Storage(t)
+	defer server.Close()
+	testBackend(t, b)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Backend stores archived videos. Keys are slash-separated paths like "{stream id}/{YYYYMMDD}/{file name}".
+type Backend interface {
+	Put(key string, r io.Reader, size int64, contentType string) error
+	Get(key string) (ReadSeekCloser, error)
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	List(prefix string) ([]*ObjectInfo, error)
+	Delete(key string) error
+	Stat(key string) (*ObjectInfo, error)
+}
+
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	ETag    string    `json:"etag"`
+}
+
+// Options selects and configures a backend
+type Options struct {
+	Remote    bool
+	Address   string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	UseSSL    bool
+	RecordDir string
+}
+
+func New(opt *Options) (Backend, error) {
+	if opt.Remote {
+		return NewS3Storage(opt.Address, opt.AccessKey, opt.SecretKey, opt.Bucket, opt.UseSSL)
+	}
+	return NewLocalStorage(opt.RecordDir)
+}
+
+func PutFile(b Backend, key, path, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileStat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key, file, fileStat.Size(), contentType)
+}
+
+// DeletePrefix deletes every object under the prefix and returns the number of deleted objects
+func DeletePrefix(b Backend, prefix string) (int, error) {
+	list, err := b.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, obj := range list {
+		if err := b.Delete(obj.Key); err != nil && err != ErrNotFound {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// GetSize returns the total size of objects under the prefix
+func GetSize(b Backend, prefix string) (int64, error) {
+	list, err := b.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, obj := range list {
+		size += obj.Size
+	}
+	return size, nil
+}
+
+func Key(elem ...string) string {
+	return strings.Join(elem, "/")
+}
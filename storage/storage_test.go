@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rtsp-stream-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func put(t *testing.T, b Backend, key string, data []byte) {
+	if err := b.Put(key, bytes.NewReader(data), int64(len(data)), "video/mp2t"); err != nil {
+		t.Fatalf("failed to put %s: %v", key, err)
+	}
+}
+
+func get(t *testing.T, b Backend, key string) []byte {
+	r, err := b.Get(key)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", key, err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	return data
+}
+
+func listKeys(t *testing.T, b Backend, prefix string) []string {
+	list, err := b.List(prefix)
+	if err != nil {
+		t.Fatalf("failed to list %q: %v", prefix, err)
+	}
+	keys := make([]string, 0, len(list))
+	for _, obj := range list {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// testBackend checks the behavior which every backend should have
+func testBackend(t *testing.T, b Backend) {
+	objects := map[string][]byte{
+		"1/20200301/media0.ts":   bytes.Repeat([]byte("a"), 1000),
+		"1/20200301/index.m3u8":  []byte("#EXTM3U\n"),
+		"1/20200302/media0.ts":   bytes.Repeat([]byte("b"), 10),
+		"10/20200301/media0.ts":  []byte("c"),
+		"1/20200301-x/media0.ts": []byte("d"),
+	}
+	for key, data := range objects {
+		put(t, b, key, data)
+	}
+
+	// Get
+	for key, data := range objects {
+		if got := get(t, b, key); !bytes.Equal(got, data) {
+			t.Errorf("%s: expected %q, got %q", key, data, got)
+		}
+	}
+	if _, err := b.Get("1/20200303/media0.ts"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// GetRange
+	r, err := b.GetRange("1/20200301/index.m3u8", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "EXT" {
+		t.Errorf("expected %q, got %q (%v)", "EXT", data, err)
+	}
+	if _, err := b.GetRange("1/20200303/media0.ts", 0, 1); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Overwrite
+	put(t, b, "1/20200302/media0.ts", []byte("new"))
+	if got := get(t, b, "1/20200302/media0.ts"); string(got) != "new" {
+		t.Errorf("expected overwritten object, got %q", got)
+	}
+
+	// Stat
+	info, err := b.Stat("1/20200301/media0.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "1/20200301/media0.ts" || info.Size != 1000 || len(info.ETag) < 1 || info.ModTime.IsZero() {
+		t.Errorf("unexpected object info: %+v", info)
+	}
+	if _, err := b.Stat("1/20200303/media0.ts"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// List
+	tests := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"1/20200301/", []string{"1/20200301/index.m3u8", "1/20200301/media0.ts"}},
+		{"1/20200301", []string{"1/20200301-x/media0.ts", "1/20200301/index.m3u8", "1/20200301/media0.ts"}},
+		{"1/", []string{"1/20200301-x/media0.ts", "1/20200301/index.m3u8", "1/20200301/media0.ts", "1/20200302/media0.ts"}},
+		{"1", []string{"1/20200301-x/media0.ts", "1/20200301/index.m3u8", "1/20200301/media0.ts", "1/20200302/media0.ts", "10/20200301/media0.ts"}},
+		{"2/", []string{}},
+	}
+	for _, tt := range tests {
+		if keys := listKeys(t, b, tt.prefix); strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+			t.Errorf("List(%q): expected %v, got %v", tt.prefix, tt.keys, keys)
+		}
+	}
+	if size, err := GetSize(b, "1/20200301/"); err != nil || size != 1008 {
+		t.Errorf("expected size 1008, got %d (%v)", size, err)
+	}
+
+	// Delete
+	if err := b.Delete("1/20200301/media0.ts"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat("1/20200301/media0.ts"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after deletion, got %v", err)
+	}
+	if n, err := DeletePrefix(b, "1/"); err != nil || n != 3 {
+		t.Errorf("expected 3 deleted objects, got %d (%v)", n, err)
+	}
+	if keys := listKeys(t, b, ""); strings.Join(keys, ",") != "10/20200301/media0.ts" {
+		t.Errorf("unexpected objects after deletion: %v", keys)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	root := newTempDir(t)
+	defer os.RemoveAll(root)
+	b, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+
+	if err := b.Delete("1/20200301/media0.ts"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Empty directories are removed with the last object
+	if _, err := os.Stat(filepath.Join(root, "1")); !os.IsNotExist(err) {
+		t.Errorf("expected empty directories removed, got %v", err)
+	}
+}
+
+func TestLocalStorageListIgnoresOtherDirectories(t *testing.T) {
+	root := newTempDir(t)
+	defer os.RemoveAll(root)
+	b, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, b, "1/20200301/media0.ts", []byte("a"))
+	put(t, b, "2/20200301/media0.ts", []byte("b"))
+
+	// Unreadable directories out of the prefix don't break listing
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	other := filepath.Join(root, "2")
+	if err := os.Chmod(other, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(other, 0755)
+	if keys := listKeys(t, b, "1/"); strings.Join(keys, ",") != "1/20200301/media0.ts" {
+		t.Errorf("unexpected objects: %v", keys)
+	}
+}
@@ -0,0 +1,149 @@
+package streaming
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"sort"
+	"time"
+)
+
+// AddAnnotation saves the new annotation with a new ID
+func (s *Stream) AddAnnotation(a *common.Annotation) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(common.AnnotationBucket)
+		if err != nil {
+			return err
+		}
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		a.Id = int64(id)
+		a.StreamId = s.Id
+		a.Created = time.Now().Unix()
+		a.Updated = a.Created
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		return b.Put(common.Int64ToBytes(a.Id), data)
+	})
+}
+
+func (s *Stream) GetAnnotation(id int64) (*common.Annotation, error) {
+	var a *common.Annotation
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.AnnotationBucket)
+		if b == nil {
+			return nil
+		}
+		data := b.Get(common.Int64ToBytes(id))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, common.ErrorAnnotationNotFound
+	}
+	return a, nil
+}
+
+// UpdateAnnotation replaces the annotation; the creation time is kept
+func (s *Stream) UpdateAnnotation(a *common.Annotation) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.AnnotationBucket)
+		if b == nil {
+			return common.ErrorAnnotationNotFound
+		}
+		key := common.Int64ToBytes(a.Id)
+		data := b.Get(key)
+		if data == nil {
+			return common.ErrorAnnotationNotFound
+		}
+		var old common.Annotation
+		if err := json.Unmarshal(data, &old); err != nil {
+			return err
+		}
+		a.StreamId = s.Id
+		a.Created = old.Created
+		a.Updated = time.Now().Unix()
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+}
+
+func (s *Stream) DeleteAnnotation(id int64) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.AnnotationBucket)
+		if b == nil || b.Get(common.Int64ToBytes(id)) == nil {
+			return common.ErrorAnnotationNotFound
+		}
+		return b.Delete(common.Int64ToBytes(id))
+	})
+}
+
+// GetAnnotations returns annotations matched by the filter in order of time
+func (s *Stream) GetAnnotations(filter *common.AnnotationFilter) ([]*common.Annotation, error) {
+	list := make([]*common.Annotation, 0)
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.AnnotationBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var a common.Annotation
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if filter.Match(&a) {
+				list = append(list, &a)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].From < list[j].From
+	})
+	return list, err
+}
+
+// DeleteAnnotationsBefore deletes annotations which ended before the time unless they're kept
+func (s *Stream) DeleteAnnotationsBefore(t time.Time, keep func(a *common.Annotation) bool) (int, error) {
+	count := 0
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.AnnotationBucket)
+		if b == nil {
+			return nil
+		}
+		keys := make([][]byte, 0)
+		err := b.ForEach(func(k, v []byte) error {
+			var a common.Annotation
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if a.To < t.Unix() && !keep(&a) {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	return count, err
+}
@@ -3,6 +3,7 @@ package streaming
 import (
 	"bufio"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/boltdb/bolt"
 	"github.com/devplayg/rtsp-stream/common"
@@ -10,6 +11,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -100,8 +102,18 @@ func (s *Assistant) captureLiveM3u8(size int) error {
 }
 
 func (s *Assistant) saveSegments(segments map[int64]*common.Segment) error {
+	// Segments are saved in order so that they are chained in order
+	seqIds := make([]int64, 0, len(segments))
+	for seqId := range segments {
+		seqIds = append(seqIds, seqId)
+	}
+	sort.Slice(seqIds, func(i, j int) bool {
+		return seqIds[i] < seqIds[j]
+	})
+
 	return s.stream.DB.Update(func(tx *bolt.Tx) error {
-		for seqId, seg := range segments {
+		for _, seqId := range seqIds {
+			seg := segments[seqId]
 			bucketName := []byte(time.Unix(seg.UnixTime, 0).In(common.Loc).Format(common.DateFormat))
 			bucket, err := tx.CreateBucketIfNotExists(bucketName)
 			if err != nil {
@@ -118,6 +130,9 @@ func (s *Assistant) saveSegments(segments map[int64]*common.Segment) error {
 			if err = bucket.Put([]byte(timeStr), seg.Data); err != nil {
 				return err
 			}
+			if err = appendToChain(tx, s.stream.Id, string(bucketName), []byte(timeStr), seg.Data); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -131,7 +146,8 @@ func (s *Assistant) generateSegments(playlist *m3u8.MediaPlaylist) (map[int64]*c
 			continue
 		}
 
-		file, err := os.Stat(filepath.Join(s.stream.liveDir, seg.URI))
+		path := filepath.Join(s.stream.liveDir, seg.URI)
+		file, err := os.Stat(path)
 		if err != nil {
 			log.Error(err)
 			continue
@@ -154,7 +170,20 @@ func (s *Assistant) generateSegments(playlist *m3u8.MediaPlaylist) (map[int64]*c
 			"str":            str,
 			"segIdConverted": seqId,
 		}).Trace("mediaPlayList")
-		data, _ := json.Marshal(common.NewSegment(seqId, seg.Duration, seg.URI, file.ModTime().In(common.Loc)))
+		hash, err := GetHashFromFile(path)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		record := common.NewSegment(seqId, seg.Duration, seg.URI, file.ModTime().In(common.Loc))
+		record.Size = file.Size()
+		record.Hash = hex.EncodeToString(hash)
+		if record.Keyframes, err = GetKeyframesFromFile(path, seg.Duration); err != nil {
+			log.WithFields(log.Fields{
+				"path": path,
+			}).Debug(err)
+		}
+		data, _ := json.Marshal(record)
 		segment.Data = data
 		m[seqId] = segment
 	}
@@ -0,0 +1,201 @@
+package streaming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/devplayg/rtsp-stream/common"
+	"strconv"
+)
+
+// appendToChain links the new segment record to the chain of the date. Records older than the last entry are not linked.
+func appendToChain(tx *bolt.Tx, streamId int64, date string, key, data []byte) error {
+	chain, err := tx.CreateBucketIfNotExists(common.ChainBucket)
+	if err != nil {
+		return err
+	}
+	b, err := chain.CreateBucketIfNotExists([]byte(date))
+	if err != nil {
+		return err
+	}
+
+	prev := getChainGenesis(streamId, date)
+	if _, v := b.Cursor().Last(); v != nil {
+		var last common.ChainEntry
+		if err := json.Unmarshal(v, &last); err != nil {
+			return err
+		}
+		if string(key) <= last.Key {
+			return nil
+		}
+		prev = last.Hash
+	}
+
+	recordHash := sha256.Sum256(data)
+	entry := &common.ChainEntry{
+		Key:        string(key),
+		RecordHash: hex.EncodeToString(recordHash[:]),
+		Prev:       prev,
+	}
+	entry.Hash = getChainHash(entry)
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(common.Int64ToBytes(int64(seq)), value)
+}
+
+// getChainGenesis binds the chain to the stream and date
+func getChainGenesis(streamId int64, date string) string {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(streamId, 10) + "/" + date))
+	return hex.EncodeToString(sum[:])
+}
+
+func getChainHash(entry *common.ChainEntry) string {
+	sum := sha256.Sum256([]byte(entry.Prev + entry.Key + entry.RecordHash))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Stream) GetChainDates() ([]string, error) {
+	dates := make([]string, 0)
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		chain := tx.Bucket(common.ChainBucket)
+		if chain == nil {
+			return nil
+		}
+		return chain.ForEach(func(k, v []byte) error {
+			if v == nil {
+				dates = append(dates, string(k))
+			}
+			return nil
+		})
+	})
+	return dates, err
+}
+
+func (s *Stream) GetChainHead(date string) (*common.ChainHead, error) {
+	head := &common.ChainHead{Date: date, Hash: getChainGenesis(s.Id, date)}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		chain := tx.Bucket(common.ChainBucket)
+		if chain == nil {
+			return nil
+		}
+		b := chain.Bucket([]byte(date))
+		if b == nil {
+			return nil
+		}
+		k, v := b.Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		var last common.ChainEntry
+		if err := json.Unmarshal(v, &last); err != nil {
+			return err
+		}
+		head.Count = common.BytesToInt64(k)
+		head.Hash = last.Hash
+		return nil
+	})
+	return head, err
+}
+
+// VerifyChain checks links of the chain, segment records and the anchored head
+func (s *Stream) VerifyChain(date string, anchor *common.ChainHead) (*common.ChainStatus, error) {
+	status := &common.ChainStatus{
+		StreamId: s.Id,
+		Date:     date,
+		Head:     getChainGenesis(s.Id, date),
+		Anchor:   anchor,
+		Errors:   make([]string, 0),
+	}
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(date))
+		chained := make(map[string]bool)
+
+		if chain := tx.Bucket(common.ChainBucket); chain != nil {
+			if b := chain.Bucket([]byte(date)); b != nil {
+				prev := status.Head
+				lastKey := ""
+				err := b.ForEach(func(k, v []byte) error {
+					var entry common.ChainEntry
+					if err := json.Unmarshal(v, &entry); err != nil {
+						return err
+					}
+					status.Count++
+					seq := common.BytesToInt64(k)
+					if seq != status.Count {
+						status.Errors = append(status.Errors, fmt.Sprintf("entry %d: missing entry %d", seq, status.Count))
+						status.Count = seq
+					}
+					if entry.Prev != prev || getChainHash(&entry) != entry.Hash {
+						status.Errors = append(status.Errors, fmt.Sprintf("entry %d: broken link", seq))
+					}
+					if entry.Key <= lastKey {
+						status.Errors = append(status.Errors, fmt.Sprintf("entry %d: reordered (%s)", seq, entry.Key))
+					}
+					prev, lastKey = entry.Hash, entry.Key
+					chained[entry.Key] = true
+
+					if anchor != nil && seq == anchor.Count && entry.Hash != anchor.Hash {
+						status.Errors = append(status.Errors, fmt.Sprintf("entry %d: anchored head mismatch", seq))
+					}
+
+					var data []byte
+					if records != nil {
+						data = records.Get([]byte(entry.Key))
+					}
+					if data == nil {
+						status.Errors = append(status.Errors, "deleted: "+entry.Key)
+						return nil
+					}
+					sum := sha256.Sum256(data)
+					if hex.EncodeToString(sum[:]) != entry.RecordHash {
+						status.Errors = append(status.Errors, "modified: "+entry.Key)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				status.Head = prev
+			}
+		}
+
+		if records != nil {
+			_ = records.ForEach(func(k, v []byte) error {
+				if !chained[string(k)] {
+					status.Unchained++
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if anchor != nil && status.Count < anchor.Count {
+		status.Errors = append(status.Errors, fmt.Sprintf("truncated: %d entries anchored, %d found", anchor.Count, status.Count))
+	}
+	status.Valid = len(status.Errors) == 0
+	return status, nil
+}
+
+func (s *Stream) DeleteChain(date string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		chain := tx.Bucket(common.ChainBucket)
+		if chain == nil || chain.Bucket([]byte(date)) == nil {
+			return nil
+		}
+		return chain.DeleteBucket([]byte(date))
+	})
+}
@@ -0,0 +1,150 @@
+package streaming
+
+import (
+	"bufio"
+	"errors"
+	"github.com/devplayg/rtsp-stream/common"
+	"io"
+	"os"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	ptsWrap      = 1 << 33
+
+	// Tables written more than this before a keyframe are not included in its byte range
+	maxTableDistance = 32 * tsPacketSize
+)
+
+var errInvalidTsPacket = errors.New("invalid MPEG-TS packet")
+
+// GetKeyframesFromFile returns the keyframe index of the MPEG-TS segment
+func GetKeyframesFromFile(path string, duration float64) ([]*common.Keyframe, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return GetKeyframes(file, duration)
+}
+
+// GetKeyframes scans MPEG-TS packets for video frames flagged as random access points.
+// The byte range of a keyframe begins at the PAT just before it, so that it can be decoded by itself,
+// and ends where the next video frame begins.
+func GetKeyframes(r io.Reader, duration float64) ([]*common.Keyframe, error) {
+	keyframes := make([]*common.Keyframe, 0)
+	var (
+		reader    = bufio.NewReaderSize(r, 64*tsPacketSize)
+		packet    = make([]byte, tsPacketSize)
+		offset    int64
+		patOffset int64 = -1
+		videoPid        = -1
+		firstPts  int64 = -1
+		current   *common.Keyframe
+	)
+	for ; ; offset += tsPacketSize {
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		if packet[0] != tsSyncByte {
+			return nil, errInvalidTsPacket
+		}
+		pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+		if pid == 0 {
+			patOffset = offset
+			continue
+		}
+		if packet[1]&0x40 == 0 { // payload_unit_start_indicator
+			continue
+		}
+
+		payload, randomAccess := getTsPayload(packet)
+		if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+			continue
+		}
+		if streamId := payload[3]; streamId < 0xe0 || streamId > 0xef { // video streams
+			continue
+		}
+		if videoPid < 0 {
+			videoPid = pid
+		}
+		if pid != videoPid {
+			continue
+		}
+
+		// A video frame begins
+		if current != nil {
+			current.Length = offset - current.Offset
+			current = nil
+		}
+		pts, ok := getPesPts(payload)
+		if !ok {
+			continue
+		}
+		if firstPts < 0 {
+			firstPts = pts
+		}
+		if !randomAccess {
+			continue
+		}
+		current = &common.Keyframe{
+			Time:   float64((pts-firstPts+ptsWrap)%ptsWrap) / 90000,
+			Offset: offset,
+		}
+		if patOffset >= 0 && offset-patOffset <= maxTableDistance {
+			current.Offset = patOffset
+		}
+		keyframes = append(keyframes, current)
+	}
+	if current != nil {
+		current.Length = offset - current.Offset
+	}
+
+	for i, k := range keyframes {
+		if i+1 < len(keyframes) {
+			k.Duration = keyframes[i+1].Time - k.Time
+			continue
+		}
+		if k.Duration = duration - k.Time; k.Duration < 0 {
+			k.Duration = 0
+		}
+	}
+	return keyframes, nil
+}
+
+// getTsPayload returns the payload of the packet and the random_access_indicator of its adaptation field
+func getTsPayload(packet []byte) ([]byte, bool) {
+	control := (packet[3] >> 4) & 0x03
+	start := 4
+	randomAccess := false
+	if control&0x02 != 0 { // adaptation field
+		length := int(packet[4])
+		if length > 0 {
+			randomAccess = packet[5]&0x40 != 0
+		}
+		start += 1 + length
+	}
+	if control&0x01 == 0 || start >= len(packet) {
+		return nil, randomAccess
+	}
+	return packet[start:], randomAccess
+}
+
+// getPesPts returns the presentation timestamp in 90kHz from the PES header
+func getPesPts(payload []byte) (int64, bool) {
+	if payload[7]&0x80 == 0 || len(payload) < 14 {
+		return 0, false
+	}
+	b := payload[9:14]
+	pts := int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 |
+		int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 |
+		int64(b[4]>>1)
+	return pts, true
+}
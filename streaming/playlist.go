@@ -0,0 +1,188 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Playlist types
+const (
+	PlaylistVod   = "VOD"
+	PlaylistEvent = "EVENT"
+)
+
+const playlistTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// PlaylistSegment is a media segment of a playlist
+type PlaylistSegment struct {
+	URI           string    `json:"uri"`
+	Duration      float64   `json:"d"`
+	Time          time.Time `json:"t"` // EXT-X-PROGRAM-DATE-TIME
+	Discontinuity bool      `json:"discontinuity"`
+	Length        int64     `json:"length,omitempty"` // EXT-X-BYTERANGE
+	Offset        int64     `json:"offset,omitempty"`
+}
+
+// DateRange is EXT-X-DATERANGE; attributes are X-* client attributes as quoted strings
+type DateRange struct {
+	Id         string
+	Class      string
+	Start      time.Time
+	End        time.Time // zero for a point in time
+	Attributes map[string]string
+}
+
+func (d *DateRange) encode() string {
+	str := "#EXT-X-DATERANGE:ID=" + quoteAttribute(d.Id)
+	if len(d.Class) > 0 {
+		str += ",CLASS=" + quoteAttribute(d.Class)
+	}
+	str += ",START-DATE=" + quoteAttribute(d.Start.Format(playlistTimeFormat))
+	if !d.End.IsZero() {
+		str += ",END-DATE=" + quoteAttribute(d.End.Format(playlistTimeFormat))
+	}
+	names := make([]string, 0, len(d.Attributes))
+	for name := range d.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		str += ",X-" + strings.ToUpper(name) + "=" + quoteAttribute(d.Attributes[name])
+	}
+	return str
+}
+
+// quoteAttribute makes a quoted-string which can't have double quotes and line feeds
+func quoteAttribute(str string) string {
+	return `"` + strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(str) + `"`
+}
+
+// Playlist is a HLS media playlist for recorded videos.
+//
+// Archived playlists need EXT-X-PROGRAM-DATE-TIME and have tens of thousands of segments,
+// and the encoder of grafov/m3u8 uses a global cache which is not safe for concurrent use.
+type Playlist struct {
+	Type          string
+	MediaSequence int64
+	Ended         bool
+	IframesOnly   bool // EXT-X-I-FRAMES-ONLY; segments are byte ranges of keyframes
+	Segments      []*PlaylistSegment
+	DateRanges    []*DateRange
+}
+
+func NewPlaylist(playlistType string) *Playlist {
+	return &Playlist{
+		Type:     playlistType,
+		Segments: make([]*PlaylistSegment, 0),
+	}
+}
+
+func ParsePlaylist(r io.Reader) (*Playlist, error) {
+	playlist := NewPlaylist("")
+	seg := &PlaylistSegment{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) < 1:
+		case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
+			playlist.Type = strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:")
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			playlist.MediaSequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case line == "#EXT-X-ENDLIST":
+			playlist.Ended = true
+		case line == "#EXT-X-I-FRAMES-ONLY":
+			playlist.IframesOnly = true
+		case line == "#EXT-X-DISCONTINUITY":
+			seg.Discontinuity = true
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+			if err != nil {
+				return nil, err
+			}
+			seg.Time = t
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			str := strings.SplitN(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"), "@", 2)
+			seg.Length, _ = strconv.ParseInt(str[0], 10, 64)
+			if len(str) > 1 {
+				seg.Offset, _ = strconv.ParseInt(str[1], 10, 64)
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			str := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)
+			duration, err := strconv.ParseFloat(str[0], 64)
+			if err != nil {
+				return nil, err
+			}
+			seg.Duration = duration
+		case strings.HasPrefix(line, "#"):
+		default:
+			seg.URI = line
+			playlist.Segments = append(playlist.Segments, seg)
+			seg = &PlaylistSegment{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+func (p *Playlist) TargetDuration() int {
+	var max float64
+	for _, seg := range p.Segments {
+		if seg.Duration > max {
+			max = seg.Duration
+		}
+	}
+	return int(math.Ceil(max))
+}
+
+// Duration returns the total duration in seconds
+func (p *Playlist) Duration() float64 {
+	var total float64
+	for _, seg := range p.Segments {
+		total += seg.Duration
+	}
+	return total
+}
+
+func (p *Playlist) Encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:4\n")
+	if len(p.Type) > 0 {
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:" + p.Type + "\n")
+	}
+	if p.IframesOnly {
+		buf.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	}
+	buf.WriteString("#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(p.MediaSequence, 10) + "\n")
+	buf.WriteString("#EXT-X-TARGETDURATION:" + strconv.Itoa(p.TargetDuration()) + "\n")
+	for _, d := range p.DateRanges {
+		buf.WriteString(d.encode() + "\n")
+	}
+	for _, seg := range p.Segments {
+		if seg.Discontinuity {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if !seg.Time.IsZero() {
+			buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + seg.Time.Format(playlistTimeFormat) + "\n")
+		}
+		if seg.Length > 0 {
+			buf.WriteString("#EXT-X-BYTERANGE:" + strconv.FormatInt(seg.Length, 10) + "@" + strconv.FormatInt(seg.Offset, 10) + "\n")
+		}
+		buf.WriteString("#EXTINF:" + strconv.FormatFloat(seg.Duration, 'f', 6, 64) + ",\n")
+		buf.WriteString(seg.URI + "\n")
+	}
+	if p.Ended {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return buf.Bytes()
+}
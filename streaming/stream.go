@@ -18,46 +18,53 @@ import (
 )
 
 type Stream struct {
-	Id                 int64                `json:"id"`           // Stream unique ID
-	Uri                string               `json:"uri"`          // Stream URL
-	Name               string               `json:"name"`         // Name
-	Username           string               `json:"username"`     // Stream username
-	Password           string               `json:"password"`     // Stream password
-	Recording          bool                 `json:"recording"`    // Is recording
-	Enabled            bool                 `json:"enabled"`      // Enabled
-	ProtocolInfo       *common.ProtocolInfo `json:"protocolInfo"` // Protocol info
-	UriHash            string               `json:"uriHash"`      // URL Hash
-	Cmd                *exec.Cmd            `json:"-"`            // Command
-	liveDir            string               `json:"-"`            // Live video directory
-	Status             int                  `json:"status"`       // Stream status
-	DataRetentionHours int                  `json:"dataRetentionHours"`
-	Pid                int                  `json:"pid"`
-	LastStreamUpdated  time.Time            `json:"lastStreamUpdated"`
-	MaxStreamSeqId     int64                `json:"maxStreamSeqId"`
-	Created            int64                `json:"created"`
-	Updated            int64                `json:"updated"`
-	Seq                int                  `json:"seq"`
-	DB                 *bolt.DB             `json:"-"`
-	LastAttemptTime    time.Time            `json:"-"`
+	Id                 int64                   `json:"id"`           // Stream unique ID
+	Uri                string                  `json:"uri"`          // Stream URL
+	Name               string                  `json:"name"`         // Name
+	Username           string                  `json:"username"`     // Stream username
+	Password           string                  `json:"password"`     // Stream password
+	Recording          bool                    `json:"recording"`    // Is recording
+	Enabled            bool                    `json:"enabled"`      // Enabled
+	ProtocolInfo       *common.ProtocolInfo    `json:"protocolInfo"` // Protocol info
+	UriHash            string                  `json:"uriHash"`      // URL Hash
+	Cmd                *exec.Cmd               `json:"-"`            // Command
+	liveDir            string                  `json:"-"`            // Live video directory
+	Status             int                     `json:"status"`       // Stream status
+	DataRetentionHours int                     `json:"dataRetentionHours"`
+	QuotaBytes         int64                   `json:"quotaBytes"` // Bytes of archived and live videos (0: unlimited)
+	Group              string                  `json:"group"`
+	Transcode          *common.TranscodePolicy `json:"transcode"` // Re-encoding policy of archived days
+	Pid                int                     `json:"pid"`
+	LastStreamUpdated  time.Time               `json:"lastStreamUpdated"`
+	MaxStreamSeqId     int64                   `json:"maxStreamSeqId"`
+	Created            int64                   `json:"created"`
+	Updated            int64                   `json:"updated"`
+	Seq                int                     `json:"seq"`
+	DB                 *bolt.DB                `json:"-"`
+	LastAttemptTime    time.Time               `json:"-"`
 	assistant          *Assistant
+	stopReason         string
 	ctx                context.Context
 	cancel             context.CancelFunc
 	// waitTimeUntilStreamStarts time.Duration
 }
 
 type SimpleStream struct {
-	Id                 int64     `json:"id"`        // Stream unique ID
-	Uri                string    `json:"uri"`       // Stream URL
-	Name               string    `json:"name"`      // Name
-	Recording          bool      `json:"recording"` // Is recording
-	Enabled            bool      `json:"enabled"`   // Enabled
-	Status             int       `json:"status"`    // Stream status
-	DataRetentionHours int       `json:"dataRetentionHours"`
-	LastStreamUpdated  time.Time `json:"lastStreamUpdated"`
-	MaxStreamSeqId     int64     `json:"maxStreamSeqId"`
-	Seq                int       `json:"seq"`
-	Created            int64     `json:"created"`
-	Updated            int64     `json:"updated"`
+	Id                 int64                   `json:"id"`        // Stream unique ID
+	Uri                string                  `json:"uri"`       // Stream URL
+	Name               string                  `json:"name"`      // Name
+	Recording          bool                    `json:"recording"` // Is recording
+	Enabled            bool                    `json:"enabled"`   // Enabled
+	Status             int                     `json:"status"`    // Stream status
+	DataRetentionHours int                     `json:"dataRetentionHours"`
+	QuotaBytes         int64                   `json:"quotaBytes"`
+	Group              string                  `json:"group"`
+	Transcode          *common.TranscodePolicy `json:"transcode"`
+	LastStreamUpdated  time.Time               `json:"lastStreamUpdated"`
+	MaxStreamSeqId     int64                   `json:"maxStreamSeqId"`
+	Seq                int                     `json:"seq"`
+	Created            int64                   `json:"created"`
+	Updated            int64                   `json:"updated"`
 }
 
 func NewStream() *Stream {
@@ -166,6 +173,12 @@ func (s *Stream) Start() (int, error) {
 			//metaFilePath := filepath.Join(s.liveDir, s.ProtocolInfo.MetaFileName)
 			//os.Remove(metaFilePath)
 			s.Status = common.Stopped
+			reason := s.stopReason
+			if len(reason) < 1 {
+				reason = common.ReasonFfmpegExited
+			}
+			s.stopReason = ""
+			s.RecordEvent(common.Stopped, reason)
 		}()
 		err := s.Cmd.Run()
 		log.WithFields(log.Fields{
@@ -188,8 +201,10 @@ func (s *Stream) Start() (int, error) {
 	select {
 	case count := <-startedChan:
 		s.Status = common.Started
+		s.RecordEvent(common.Started, "")
 		return count, nil
 	case <-s.ctx.Done():
+		s.SetStopReason(common.ReasonFailed)
 		if err := s.Stop(); err != nil {
 			log.Error("failed to stop stream: " + err.Error())
 		}
@@ -210,6 +225,70 @@ func (s *Stream) Stop() error {
 	return err
 }
 
+// SetStopReason sets the reason recorded when the ffmpeg process exits
+func (s *Stream) SetStopReason(reason string) {
+	s.stopReason = reason
+}
+
+func (s *Stream) RecordEvent(status int, reason string) {
+	if s.DB == nil {
+		return
+	}
+	t := time.Now()
+	data, err := json.Marshal(common.NewStreamEvent(t, status, reason))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	err = s.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(common.EventBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(common.Int64ToBytes(t.UnixNano()), data)
+	})
+	if err != nil && err != bolt.ErrDatabaseNotOpen {
+		log.Error(err)
+	}
+}
+
+// GetEvents returns the events between "from" and "to" and the last event before "from"
+func (s *Stream) GetEvents(from, to time.Time) ([]*common.StreamEvent, *common.StreamEvent, error) {
+	events := make([]*common.StreamEvent, 0)
+	var last *common.StreamEvent
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(common.EventBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		k, v := c.Seek(common.Int64ToBytes(from.UnixNano()))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k != nil {
+			var e common.StreamEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			last = &e
+		}
+
+		end := to.UnixNano()
+		for k, v = c.Seek(common.Int64ToBytes(from.UnixNano())); k != nil && common.BytesToInt64(k) <= end; k, v = c.Next() {
+			var e common.StreamEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			events = append(events, &e)
+		}
+		return nil
+	})
+	return events, last, err
+}
+
 func (s *Stream) makeM3u8Tags(segments []*common.Segment) string {
 	size := uint(len(segments))
 	playlist, _ := m3u8.NewMediaPlaylist(size, size)
@@ -244,6 +323,10 @@ func (s *Stream) GetM3u8Tags(date string) (string, error) {
 	return tags, nil
 }
 
+func (s *Stream) GetSegments(date string) ([]*common.Segment, error) {
+	return s.getM3u8Segments(date)
+}
+
 func (s *Stream) getM3u8Segments(date string) ([]*common.Segment, error) {
 	segments := make([]*common.Segment, 0)
 	err := s.DB.View(func(tx *bolt.Tx) error {
@@ -300,6 +383,9 @@ func (s *Stream) Simplify() *SimpleStream {
 		Enabled:            s.Enabled,
 		Status:             s.Status,
 		DataRetentionHours: s.DataRetentionHours,
+		QuotaBytes:         s.QuotaBytes,
+		Group:              s.Group,
+		Transcode:          s.Transcode,
 		LastStreamUpdated:  s.LastStreamUpdated,
 		MaxStreamSeqId:     s.MaxStreamSeqId,
 		Created:            s.Created,
@@ -308,3 +394,57 @@ func (s *Stream) Simplify() *SimpleStream {
 	}
 
 }
+
+// DeleteDataBefore deletes segment records of the days which ended before the time and events before the time.
+// The last event before the time is kept to know the state at the time.
+func (s *Stream) DeleteDataBefore(t time.Time) ([]string, error) {
+	dates := make([]string, 0)
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			start, err := time.ParseInLocation(common.DateFormat, string(name), common.Loc)
+			if err != nil {
+				return nil
+			}
+			if start.AddDate(0, 0, 1).After(t) {
+				return nil
+			}
+			dates = append(dates, string(name))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, date := range dates {
+			if err := tx.DeleteBucket([]byte(date)); err != nil {
+				return err
+			}
+			for _, name := range [][]byte{common.ArchiveBucket, common.ChainBucket} {
+				if b := tx.Bucket(name); b != nil && b.Bucket([]byte(date)) != nil {
+					if err := b.DeleteBucket([]byte(date)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		b := tx.Bucket(common.EventBucket)
+		if b == nil {
+			return nil
+		}
+		keys := make([][]byte, 0)
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && common.BytesToInt64(k) < t.UnixNano(); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		if len(keys) > 0 {
+			keys = keys[:len(keys)-1]
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return dates, err
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/devplayg/rtsp-stream/common"
 	"github.com/gorilla/mux"
 	"github.com/minio/highwayhash"
-	"github.com/minio/minio-go"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -67,12 +66,16 @@ func GetHashFromFile(path string) ([]byte, error) {
 	}
 	defer file.Close()
 
+	return GetHash(file)
+}
+
+func GetHash(r io.Reader) ([]byte, error) {
 	hash, err := highwayhash.New128(common.HashKey)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err = io.Copy(hash, file); err != nil {
+	if _, err = io.Copy(hash, r); err != nil {
 		return nil, err
 	}
 
@@ -189,27 +192,6 @@ func ParseAndGetStreamId(r *http.Request) (int64, error) {
 //    return "#EXT-X-ENDLIST"
 //}
 
-func SendToStorage(bucketName, objectName, path, contentType string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	fileStat, err := file.Stat()
-	if err != nil {
-		return err
-	}
-
-	if len(contentType) < 1 {
-		contentType = "application/octet-stream"
-	}
-	if _, err = common.MinioClient.PutObject(bucketName, objectName, file, fileStat.Size(), minio.PutObjectOptions{ContentType: contentType}); err != nil {
-		return err
-	}
-	return nil
-}
-
 func GetVideoFileSeq(name string) (int, error) {
 	str := strings.TrimPrefix(filepath.Base(name), common.VideoFilePrefix)
 	str = strings.TrimSuffix(str, common.VideoFileExt)
@@ -66,6 +66,9 @@ func baseFluid() string {
 </head>
 
 <body>
+    <form method="post" action="/logout" class="position-absolute" style="top: 4px; right: 8px; z-index: 1000;">
+        <button type="submit" class="btn btn-link btn-sm">Log out</button>
+    </form>
     <div class="container-fluid">
 {{ block "content" . }}{{ end }}
     </div>
@@ -82,6 +85,14 @@ func baseFluid() string {
     <script src="/assets/plugins/videojs/video.min.js"></script>
     <script src="/assets/plugins/videojs/videojs-http-streaming.min.js"></script>
     <script src="/assets/js/custom.js"></script>
+    <script>
+        // Sessions expire while pages are open
+        $(document).ajaxError(function(e, xhr) {
+            if (xhr.status === 401) {
+                location.href = "/login?next=" + encodeURIComponent(location.pathname + location.search);
+            }
+        });
+    </script>
 {{ block "script" . }}{{ end }}
 </body>
 
@@ -0,0 +1,33 @@
+package ui
+
+// LoginPage is rendered by itself because assets need a session
+func LoginPage() string {
+	return `<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+    <title>RTSP-Stream</title>
+    <style>
+        body { font-family: sans-serif; background: #f5f5f5; }
+        form { width: 280px; margin: 120px auto; padding: 24px; background: #fff; border: 1px solid #ddd; border-radius: 4px; }
+        input { display: block; width: 100%; box-sizing: border-box; margin-bottom: 12px; padding: 8px; }
+        button { width: 100%; padding: 8px; }
+        .error { color: #dc3545; margin-bottom: 12px; }
+    </style>
+</head>
+
+<body>
+    <form method="post" action="/login">
+        <h3>RTSP-Stream</h3>
+        {{ if .error }}<div class="error">{{ .error }}</div>{{ end }}
+        <input type="hidden" name="next" value="{{ .next }}">
+        <input type="text" name="username" placeholder="Username" autofocus required>
+        <input type="password" name="password" placeholder="Password" required>
+        <button type="submit">Log in</button>
+    </form>
+</body>
+
+</html>`
+}
@@ -15,6 +15,7 @@ func StreamPage() string {
             <div class="col">
                 <div id="toolbar-streams">
                     <button type="button" class="btn btn-primary btn-test">TEST</button>
+                    <span id="disk-status" class="badge badge-secondary" title="Disk watchdog">Disk: -</span>
                 </div>
                 <table  id="table-streams"
                         data-toggle="table"
@@ -169,6 +170,23 @@ func StreamPage() string {
 {{define "script"}}
 	<script src="/static/assets/modules/stream/formatter.js"></script>
 	<script src="/static/assets/modules/stream/streams.js"></script>
+	<script>
+		function updateDiskStatus() {
+			$.getJSON("/disk", function(status) {
+				let badge = {ok: "badge-success", warning: "badge-warning", critical: "badge-danger"}[status.level] || "badge-secondary";
+				let title = status.dirs.map(function(d) {
+					return d.path + ": " + d.freePercent.toFixed(1) + "% free, " + d.freeInodesPercent.toFixed(1) + "% inodes free";
+				}).join("\n");
+				$("#disk-status")
+					.removeClass("badge-secondary badge-success badge-warning badge-danger")
+					.addClass(badge)
+					.attr("title", title)
+					.text("Disk: " + status.level);
+			});
+		}
+		updateDiskStatus();
+		setInterval(updateDiskStatus, 30000);
+	</script>
 {{end}}
 `
 }
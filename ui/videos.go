@@ -19,13 +19,42 @@ func VideoPage() string {
         <div class="col">
             <video-js id="player" class="vjs-default-skin vjs-fluid">
             </video-js>
+            <div id="timeline" class="mt-2 d-none">
+                <div class="small text-muted"><span class="timeline-title"></span></div>
+                <div class="timeline-bar position-relative bg-light border" style="height: 24px;"></div>
+                <div class="d-flex justify-content-between small text-muted">
+                    <span>00:00</span><span>06:00</span><span>12:00</span><span>18:00</span><span>24:00</span>
+                </div>
+            </div>
         </div>
     </div>
+    <div class="row mt-3">
+        <div class="col">
+            <form id="form-sync" class="form-inline">
+                <select name="ids" class="form-control form-control-sm mr-2" multiple size="3"></select>
+                <input type="datetime-local" name="from" class="form-control form-control-sm mr-2" step="1" required>
+                <input type="datetime-local" name="to" class="form-control form-control-sm mr-2" step="1" required>
+                <button type="submit" class="btn btn-sm btn-primary">Sync playback</button>
+            </form>
+        </div>
+    </div>
+    <div id="sync" class="mt-2 d-none">
+        <div class="d-flex align-items-center mb-2">
+            <button type="button" class="btn btn-sm btn-secondary sync-play mr-2"><i class="fas fa-play"></i></button>
+            <select class="form-control form-control-sm sync-rate mr-2" style="width: auto;">
+                <option value="1">1x</option><option value="2">2x</option><option value="4">4x</option><option value="8">8x</option>
+            </select>
+            <input type="range" class="custom-range sync-position mr-2" min="0" max="0" step="1" value="0">
+            <span class="small text-monospace sync-time"></span>
+        </div>
+        <div class="row row-cols-3 sync-players"></div>
+    </div>
 {{end}}
 
 {{define "script"}}
 	<script src="/static/assets/modules/stream/formatter.js"></script>
 	<script src="/static/assets/modules/stream/videos.js"></script>
+	<script src="/static/assets/modules/stream/sync.js"></script>
 {{end}}
 `
 